@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/ppacher/portmaster-plugin-registry/manager"
+	"github.com/ppacher/portmaster-plugin-registry/registry"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+	"github.com/safing/portmaster/plugin/framework"
+	"github.com/safing/portmaster/plugin/shared"
+)
+
+const (
+	// defaultAPIListen is the default listen address of the management API.
+	defaultAPIListen = "127.0.0.1:8741"
+
+	// maxRequestBodySize is the maximum size of request bodies accepted by
+	// the management API.
+	maxRequestBodySize = 64 << 10
+)
+
+type (
+	// apiConfig configures the local management API. The API is only
+	// served if an api block is present in repositories.hcl.
+	apiConfig struct {
+		// Listen is the address the API is served on. It must be a
+		// loopback address.
+		Listen string `hcl:"listen,optional"`
+
+		// Token is the bearer token required to access the API. If empty,
+		// a random token is generated and stored in api.token in the plugin
+		// base directory.
+		Token string `hcl:"token,optional"`
+	}
+
+	// APIServer serves the local HTTP/JSON management API.
+	APIServer struct {
+		registry *registry.Registry
+		manager  *manager.Manager
+		token    string
+
+		// ctx is used to install, update and uninstall plugins instead of
+		// the request context so a client that disconnects never aborts
+		// or rolls back an operation halfway.
+		ctx context.Context
+	}
+
+	installRequest struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+
+	pluginDetails struct {
+		structs.PluginDesc
+
+		Versions     []string               `json:"versions"`
+		Alternatives []registry.Alternative `json:"alternatives"`
+	}
+)
+
+// StartAPIServer starts serving the management API as configured in cfg. The
+// server is shutdown when ctx is cancelled.
+func StartAPIServer(ctx context.Context, cfg apiConfig, reg *registry.Registry, mng *manager.Manager) error {
+	if cfg.Listen == "" {
+		cfg.Listen = defaultAPIListen
+	}
+
+	if err := checkLoopback(cfg.Listen); err != nil {
+		return err
+	}
+
+	if cfg.Token == "" {
+		token, err := loadOrCreateAPIToken(filepath.Join(framework.BaseDirectory(), "api.token"))
+		if err != nil {
+			return err
+		}
+
+		cfg.Token = token
+	}
+
+	srv := &APIServer{
+		registry: reg,
+		manager:  mng,
+		token:    cfg.Token,
+		ctx:      ctx,
+	}
+
+	listener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", cfg.Listen, err)
+	}
+
+	httpServer := &http.Server{
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			hclog.L().Error("failed to shutdown management API", "error", err)
+		}
+	}()
+
+	go func() {
+		hclog.L().Info("serving management API", "address", listener.Addr().String())
+
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			hclog.L().Error("management API failed", "error", err)
+		}
+	}()
+
+	return nil
+}
+
+// Handler returns the http.Handler that serves the management API. Listings
+// support ?sort=<name|version|repository|priority>, ?order=<asc|desc>,
+// ?offset= and ?limit=.
+//
+//	GET    /v1/plugins                  list available plugins, filtered by ?tag=, ?type= and ?name=
+//	GET    /v1/plugins/<name>           get the latest release, all versions and all repositories of a plugin,
+//	                                    use <repo>/<name> to select a specific repository
+//	GET    /v1/search?q=<query>         search plugins, see registry.ParseQuery
+//	GET    /v1/installed                list installed plugins
+//	POST   /v1/installed                install a plugin, body: {"name": "[<repo>/]<name>", "version": "<constraint>"}
+//	POST   /v1/installed/<name>/update  update an installed plugin, responds with {"warning": "..."} if the
+//	                                    update cannot be verified and rolled back, see manager.ErrRollbackUnsupported
+//	DELETE /v1/installed/<name>         uninstall a plugin
+//	POST   /v1/refresh                  fetch repositories and check for updates
+//
+// Installing, updating and uninstalling plugins is not aborted if the client
+// disconnects before the response has been sent.
+func (srv *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/plugins", srv.handleListPlugins)
+	mux.HandleFunc("/v1/plugins/", srv.handleGetPlugin)
+	mux.HandleFunc("/v1/search", srv.handleSearch)
+	mux.HandleFunc("/v1/installed", srv.handleInstalled)
+	mux.HandleFunc("/v1/installed/", srv.handleInstalledPlugin)
+	mux.HandleFunc("/v1/refresh", srv.handleRefresh)
+
+	return srv.authenticate(mux)
+}
+
+// authenticate rejects requests without a valid bearer token and limits the
+// size of request bodies.
+func (srv *APIServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+
+		if !strings.HasPrefix(header, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(srv.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing API token"))
+
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (srv *APIServer) handleListPlugins(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	// filters are applied on the full, sorted lists and the intersection
+	// is paginated afterwards.
+	sortOpts := registry.ListOptions{
+		SortBy:     opts.SortBy,
+		Descending: opts.Descending,
+	}
+
+	query := r.URL.Query()
+
+	var lists [][]structs.PluginDesc
+	if tag := query.Get("tag"); tag != "" {
+		lists = append(lists, srv.registry.SearchByTag(tag, sortOpts))
+	}
+	if pType := query.Get("type"); pType != "" {
+		lists = append(lists, srv.registry.SearchByType(shared.PluginType(pType), sortOpts))
+	}
+	if name := query.Get("name"); name != "" {
+		lists = append(lists, srv.registry.SearchByName(name, sortOpts))
+	}
+
+	if len(lists) == 0 {
+		lists = append(lists, srv.registry.ListPlugins(sortOpts))
+	}
+
+	writeJSON(w, http.StatusOK, registry.Paginate(intersectPlugins(lists), opts))
+}
+
+func (srv *APIServer) handleGetPlugin(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/v1/plugins/")
+
+	plg, ok := srv.registry.ByName(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, registry.ErrUnknownPlugin)
+
+		return
+	}
+
+	_, plainName := registry.SplitPluginName(name)
+
+	writeJSON(w, http.StatusOK, pluginDetails{
+		PluginDesc:   plg,
+		Versions:     srv.registry.Versions(name),
+		Alternatives: srv.registry.Alternatives(plainName),
+	})
+}
+
+func (srv *APIServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	// search results are ranked by relevance unless a sort key is
+	// requested explicitly.
+	if r.URL.Query().Get("sort") == "" {
+		opts.SortBy = ""
+	}
+
+	results, err := srv.registry.Search(r.URL.Query().Get("q"), opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if results == nil {
+		results = []registry.SearchResult{}
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (srv *APIServer) handleInstalled(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, srv.manager.InstalledPlugins())
+
+	case http.MethodPost:
+		var req installRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+
+			return
+		}
+
+		if req.Name == "" {
+			writeError(w, http.StatusBadRequest, errors.New("missing plugin name"))
+
+			return
+		}
+
+		if err := srv.manager.InstallPlugin(srv.ctx, req.Name, req.Version); err != nil {
+			writeManagerError(w, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (srv *APIServer) handleInstalledPlugin(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/installed/")
+
+	var err error
+	switch {
+	case strings.HasSuffix(path, "/update"):
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		err = srv.manager.UpdatePlugin(srv.ctx, strings.TrimSuffix(path, "/update"))
+
+		// the update has been installed but could not be verified.
+		if errors.Is(err, manager.ErrRollbackUnsupported) {
+			writeJSON(w, http.StatusOK, map[string]string{
+				"warning": err.Error(),
+			})
+
+			return
+		}
+
+	case !strings.Contains(path, "/"):
+		if !allowMethod(w, r, http.MethodDelete) {
+			return
+		}
+
+		err = srv.manager.UninstallPlugin(srv.ctx, path)
+
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+
+		return
+	}
+
+	if err != nil {
+		writeManagerError(w, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *APIServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	if err := srv.manager.Refresh(r.Context()); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// intersectPlugins returns all plugins that are part of each list.
+func intersectPlugins(lists [][]structs.PluginDesc) []structs.PluginDesc {
+	counts := make(map[string]int)
+	for _, list := range lists {
+		for _, plg := range list {
+			counts[plg.Name]++
+		}
+	}
+
+	result := []structs.PluginDesc{}
+	for _, plg := range lists[0] {
+		if counts[plg.Name] == len(lists) {
+			result = append(result, plg)
+		}
+	}
+
+	return result
+}
+
+// parseListOptions parses the sort, order, offset and limit query parameters
+// of r.
+func parseListOptions(r *http.Request) (registry.ListOptions, error) {
+	query := r.URL.Query()
+
+	sortBy, err := registry.ParseSortKey(query.Get("sort"))
+	if err != nil {
+		return registry.ListOptions{}, err
+	}
+
+	opts := registry.ListOptions{
+		SortBy: sortBy,
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return registry.ListOptions{}, fmt.Errorf("unsupported order %q", query.Get("order"))
+	}
+
+	for param, target := range map[string]*int{
+		"offset": &opts.Offset,
+		"limit":  &opts.Limit,
+	} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return registry.ListOptions{}, fmt.Errorf("invalid %s %q", param, value)
+		}
+
+		*target = n
+	}
+
+	return opts, nil
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+
+	return false
+}
+
+func writeManagerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, manager.ErrUnknownPlugin), errors.Is(err, manager.ErrNotInstalled):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, manager.ErrNoUpdate), errors.Is(err, manager.ErrInstalled):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, manager.ErrUnregisterUnsupported):
+		writeError(w, http.StatusNotImplemented, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		hclog.L().Error("failed to encode API response", "error", err)
+	}
+}
+
+// checkLoopback ensures that the listen address only binds to the loopback
+// interface.
+func checkLoopback(listen string) error {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("listen address %q is not a loopback address", listen)
+	}
+
+	return nil
+}
+
+// loadOrCreateAPIToken reads the API token from path or creates a new random
+// token if the file does not exist.
+func loadOrCreateAPIToken(path string) (string, error) {
+	blob, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(blob)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read API token: %w", err)
+	}
+
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+
+	token := hex.EncodeToString(key[:])
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write API token: %w", err)
+	}
+
+	return token, nil
+}
@@ -6,7 +6,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/hcl/v2/hclsimple"
 	"github.com/ppacher/portmaster-plugin-registry/installer"
 	"github.com/ppacher/portmaster-plugin-registry/manager"
@@ -14,6 +16,7 @@ import (
 	"github.com/ppacher/portmaster-plugin-registry/structs"
 	"github.com/safing/portmaster/plugin/framework"
 	"github.com/safing/portmaster/plugin/framework/cmds"
+	"github.com/safing/portmaster/plugin/shared/proto"
 	"github.com/spf13/cobra"
 )
 
@@ -39,18 +42,30 @@ func main() {
 }
 
 func bootstrapPlugin(ctx context.Context) error {
-	provider := registry.NewRegistry()
+	provider := registry.NewRegistry(filepath.Join(
+		framework.BaseDirectory(),
+		"index-cache",
+	))
 
-	repos, err := loadRepositories()
+	cfg, err := loadRepositories()
 	if err != nil {
 		// TODO(ppacher): create a notification for that?
 
 		return fmt.Errorf("failed to read repositories: %w", err)
 	}
 
-	for _, repo := range repos {
+	var refreshInterval time.Duration
+	if cfg.RefreshInterval != "" {
+		refreshInterval, err = time.ParseDuration(cfg.RefreshInterval)
+		if err != nil {
+			return fmt.Errorf("invalid refresh_interval: %w", err)
+		}
+	}
+
+	for _, repo := range cfg.Repositories {
 		if err := provider.AddRepository(repo); err != nil {
-			// TODO(ppacher): create a notification for that?
+			hclog.L().Error("failed to add repository", "repository", repo.Name, "error", err)
+			notifyInvalidRepository(ctx, repo, err)
 
 			continue
 		}
@@ -68,7 +83,13 @@ func bootstrapPlugin(ctx context.Context) error {
 		"registry.state.hcl",
 	)
 
-	manager := manager.NewManager(stateFile, installer, provider, framework.PluginManager())
+	manager := manager.NewManager(
+		stateFile,
+		installer,
+		provider,
+		framework.PluginManager(),
+		manager.WithRefreshInterval(refreshInterval),
+	)
 
 	// kick of the notification handler that will create error and update notifications.
 	NewNotificationHandler(manager, framework.Notify())
@@ -77,38 +98,74 @@ func bootstrapPlugin(ctx context.Context) error {
 		return err
 	}
 
+	// the API is only served once the manager has been started so requests
+	// never operate on a manager that failed to load its state.
+	if cfg.API != nil {
+		if err := StartAPIServer(framework.Context(), *cfg.API, provider, manager); err != nil {
+			return fmt.Errorf("failed to start management API: %w", err)
+		}
+	}
+
 	return nil
 }
 
-func loadRepositories() ([]structs.Repository, error) {
+// notifyInvalidRepository creates an error notification for a repository
+// that cannot be used, e.g. because it follows an unsupported release channel.
+func notifyInvalidRepository(ctx context.Context, repo structs.Repository, err error) {
+	_, err = framework.Notify().CreateNotification(ctx, &proto.Notification{
+		EventId: "plugin-registry:invalid-repository-" + repo.Name,
+		Type:    proto.NotificationType_NOTIFICATION_TYPE_ERROR,
+		Title:   "Invalid plugin repository " + repo.Name,
+		Message: "The plugin repository " + repo.Name + " has been ignored because its configuration is invalid: " + err.Error(),
+		Actions: []*proto.NotificationAction{
+			{
+				Id:   "go-away",
+				Text: "OK",
+			},
+		},
+	})
+	if err != nil {
+		hclog.L().Error("failed to create invalid-repository notification", "repository", repo.Name, "error", err)
+	}
+}
+
+// repositoryConfig is the content of the repositories.hcl file.
+type repositoryConfig struct {
+	// RefreshInterval is the interval at which repositories are fetched
+	// and checked for updates, e.g. "30m". Defaults to
+	// manager.DefaultRefreshInterval.
+	RefreshInterval string               `hcl:"refresh_interval,optional"`
+	API             *apiConfig           `hcl:"api,block"`
+	Repositories    []structs.Repository `hcl:"repository,block"`
+}
+
+func loadRepositories() (repositoryConfig, error) {
 	repositoryFile := filepath.Join(
 		framework.BaseDirectory(),
 		"repositories.hcl",
 	)
 
-	var repos struct {
-		Repositories []structs.Repository `hcl:"repository,block"`
-	}
+	var cfg repositoryConfig
 
 	blob, err := os.ReadFile(repositoryFile)
 	if err != nil && !os.IsNotExist(err) {
-		return nil, err
+		return cfg, err
 	}
 
 	if err == nil {
-		if err := hclsimple.Decode(repositoryFile, blob, nil, &repos); err != nil {
-			return nil, err
+		if err := hclsimple.Decode(repositoryFile, blob, nil, &cfg); err != nil {
+			return cfg, err
 		}
+	}
 
-		if len(repos.Repositories) > 0 {
-			return repos.Repositories, nil
+	if len(cfg.Repositories) == 0 {
+		cfg.Repositories = []structs.Repository{
+			{
+				Name: "main",
+				URL:  "https://raw.githubusercontent.com/ppacher/portmaster-plugin-registry/main/repository.hcl",
+			},
 		}
 	}
 
-	return []structs.Repository{
-		{
-			Name: "main",
-			URL:  "https://raw.githubusercontent.com/ppacher/portmaster-plugin-registry/main/repository.hcl",
-		},
-	}, nil
+	return cfg, nil
 }
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
@@ -11,20 +15,54 @@ import (
 	"github.com/safing/portmaster/plugin/shared/proto"
 )
 
+// snoozeDuration defines how long update notifications are suppressed
+// after the user selected "Later".
+const snoozeDuration = 24 * time.Hour
+
+// fetchFailedEventID is the event ID of the notification created if
+// repositories cannot be fetched. The repository name is appended for errors
+// of a single repository.
+const fetchFailedEventID = "plugin-registry:fetch-failed"
+
 type NotificationHandler struct {
 	notification.Service
 
 	manager *manager.Manager
+
+	l       sync.Mutex
+	snoozed map[string]time.Time
+
+	// pending holds the update notification currently waiting for a user
+	// action for each plugin. It is guarded by l.
+	pending map[string]*pendingUpdate
+
+	// progress holds the last download percentage reported for
+	// each plugin that is currently being installed. It is only accessed
+	// by handleEvents.
+	progress map[string]int64
+
+	// fetchFailed holds the event IDs of all fetch-failed notifications
+	// currently shown. It is only accessed by handleEvents.
+	fetchFailed map[string]struct{}
+}
+
+// pendingUpdate is an update notification waiting for a user action.
+type pendingUpdate struct {
+	version string
+	cancel  context.CancelFunc
 }
 
 func NewNotificationHandler(manager *manager.Manager, notify notification.Service) *NotificationHandler {
 	handler := &NotificationHandler{
-		Service: notify,
-		manager: manager,
+		Service:     notify,
+		manager:     manager,
+		snoozed:     make(map[string]time.Time),
+		pending:     make(map[string]*pendingUpdate),
+		progress:    make(map[string]int64),
+		fetchFailed: make(map[string]struct{}),
 	}
 
-	manager.OnFetchDone(handler.onFetchDone)
-	manager.OnUpdateAvailable(handler.onUpdateAvailable)
+	go handler.handleEvents(manager.Subscribe())
 
 	_, err := framework.Notify().CreateNotification(framework.Context(), &proto.Notification{
 		EventId:      "plugin-registry:peristent-notification",
@@ -48,13 +86,53 @@ func NewNotificationHandler(manager *manager.Manager, notify notification.Servic
 	return handler
 }
 
-func (handler *NotificationHandler) onFetchDone(err error) {
-	if err != nil {
+func (handler *NotificationHandler) handleEvents(sub *manager.Subscription) {
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case evt := <-sub.Events():
+			switch evt := evt.(type) {
+			case manager.FetchFinished:
+				handler.onFetchFinished(evt)
+			case manager.UpdateAvailable:
+				handler.onUpdateAvailable(evt.Updates)
+			case manager.InstallProgress:
+				handler.onInstallProgress(evt)
+			case manager.InstallFinished:
+				handler.onInstallFinished(evt)
+			case manager.RolledBack:
+				handler.onRollback(evt.Rollback)
+			}
+
+		case <-framework.Context().Done():
+			return
+		}
+	}
+}
+
+func (handler *NotificationHandler) onFetchFinished(evt manager.FetchFinished) {
+	eventID := fetchFailedEventID
+	if evt.Repository != "" {
+		eventID += "-" + evt.Repository
+	}
+
+	if evt.Err != nil {
+		// the user has already been notified.
+		if _, ok := handler.fetchFailed[eventID]; ok {
+			return
+		}
+
+		title := "Failed to fetch plugin repositories"
+		if evt.Repository != "" {
+			title = "Failed to fetch plugin repository " + evt.Repository
+		}
+
 		_, err := handler.CreateNotification(framework.Context(), &proto.Notification{
-			EventId: "plugin-registry:fetch-failed",
+			EventId: eventID,
 			Type:    proto.NotificationType_NOTIFICATION_TYPE_ERROR,
-			Title:   "Failed to fetch plugin repositories",
-			Message: err.Error(),
+			Title:   title,
+			Message: evt.Err.Error(),
 			Actions: []*proto.NotificationAction{
 				{
 					Id:   "go-away",
@@ -64,22 +142,66 @@ func (handler *NotificationHandler) onFetchDone(err error) {
 		})
 		if err != nil {
 			hclog.L().Error("failed to create fetch-failed notification", "error", err)
+
+			return
+		}
+
+		handler.fetchFailed[eventID] = struct{}{}
+
+		return
+	}
+
+	// errors that cannot be attributed to a single repository are
+	// resolved as well once a repository has been fetched successfully.
+	for _, id := range []string{eventID, fetchFailedEventID} {
+		if _, ok := handler.fetchFailed[id]; !ok {
+			continue
 		}
-	} else {
+
 		_, err := handler.CreateNotification(framework.Context(), &proto.Notification{
-			EventId: "plugin-registry:fetch-failed",
+			EventId: id,
 			Type:    proto.NotificationType_NOTIFICATION_TYPE_ERROR,
 			Expires: time.Now().Add(-time.Second).UnixNano(),
 		})
 		if err != nil {
 			hclog.L().Error("failed to clear fetch-failed notification", "error", err)
+
+			continue
 		}
+
+		delete(handler.fetchFailed, id)
 	}
 }
 
 func (handler *NotificationHandler) onUpdateAvailable(updates []structs.AvailableUpdate) {
+	handler.l.Lock()
+	defer handler.l.Unlock()
+
+	// stop waiting for actions on updates that are no longer available,
+	// for example because the plugin has been updated in the meantime.
+	available := make(map[string]struct{}, len(updates))
 	for _, upd := range updates {
-		_, err := handler.CreateNotification(framework.Context(), &proto.Notification{
+		available[upd.Name] = struct{}{}
+	}
+	for name, p := range handler.pending {
+		if _, ok := available[name]; !ok {
+			p.cancel()
+			delete(handler.pending, name)
+		}
+	}
+
+	for _, upd := range updates {
+		if handler.isSnoozedLocked(upd.Name) {
+			continue
+		}
+
+		// the user has already been notified about this version.
+		prev := handler.pending[upd.Name]
+		if prev != nil && prev.version == upd.NewVersion {
+			continue
+		}
+
+		actions, err := handler.CreateNotification(framework.Context(), &proto.Notification{
 			EventId: "plugin-registry:update-" + upd.Name,
 			Type:    proto.NotificationType_NOTIFICATION_TYPE_INFO,
 			Title:   upd.Name + ": new version " + upd.NewVersion + " is available",
@@ -98,6 +220,191 @@ func (handler *NotificationHandler) onUpdateAvailable(updates []structs.Availabl
 
 		if err != nil {
 			hclog.L().Error("failed to create update notification", "plugin", upd.Name, "error", err.Error())
+
+			continue
+		}
+
+		// only a single waiter per plugin so outdated notifications
+		// cannot trigger additional updates.
+		if prev != nil {
+			prev.cancel()
+		}
+
+		ctx, cancel := context.WithCancel(framework.Context())
+		p := &pendingUpdate{
+			version: upd.NewVersion,
+			cancel:  cancel,
+		}
+		handler.pending[upd.Name] = p
+
+		go handler.handleUpdateAction(ctx, p, upd, actions)
+	}
+}
+
+func (handler *NotificationHandler) handleUpdateAction(ctx context.Context, p *pendingUpdate, upd structs.AvailableUpdate, actions <-chan string) {
+	var action string
+	select {
+	case action = <-actions:
+	case <-ctx.Done():
+		return
+	}
+
+	handler.l.Lock()
+	if handler.pending[upd.Name] == p {
+		delete(handler.pending, upd.Name)
+	}
+	handler.l.Unlock()
+	p.cancel()
+
+	switch action {
+	case "update-now":
+		handler.updatePlugin(upd)
+
+	case "not-now":
+		handler.l.Lock()
+		handler.snoozed[upd.Name] = time.Now().Add(snoozeDuration)
+		handler.l.Unlock()
+	}
+}
+
+func (handler *NotificationHandler) updatePlugin(upd structs.AvailableUpdate) {
+	err := handler.manager.UpdatePlugin(framework.Context(), upd.Name)
+
+	// the update has been installed but the Portmaster cannot tell us
+	// whether it works.
+	if errors.Is(err, manager.ErrRollbackUnsupported) {
+		message := "The plugin " + upd.Name + " has been updated to version " + upd.NewVersion +
+			" but will not be rolled back automatically if the new version fails to start.\n\n" + err.Error()
+
+		_, err := handler.CreateNotification(framework.Context(), &proto.Notification{
+			EventId: "plugin-registry:update-" + upd.Name,
+			Type:    proto.NotificationType_NOTIFICATION_TYPE_WARNING,
+			Title:   upd.Name + " updated to " + upd.NewVersion,
+			Message: message,
+			Actions: []*proto.NotificationAction{
+				{
+					Id:   "go-away",
+					Text: "OK",
+				},
+			},
+		})
+		if err != nil {
+			hclog.L().Error("failed to create update notification", "plugin", upd.Name, "error", err)
+		}
+
+		return
+	}
+
+	if err != nil {
+		hclog.L().Error("failed to update plugin", "plugin", upd.Name, "error", err)
+
+		// rollbacks are already reported by onRollback
+		if errors.Is(err, manager.ErrRolledBack) {
+			return
+		}
+
+		_, err := handler.CreateNotification(framework.Context(), &proto.Notification{
+			EventId: "plugin-registry:update-failed-" + upd.Name,
+			Type:    proto.NotificationType_NOTIFICATION_TYPE_ERROR,
+			Title:   "Failed to update " + upd.Name,
+			Message: err.Error(),
+			Actions: []*proto.NotificationAction{
+				{
+					Id:   "go-away",
+					Text: "OK",
+				},
+			},
+		})
+		if err != nil {
+			hclog.L().Error("failed to create update-failed notification", "plugin", upd.Name, "error", err)
 		}
+
+		return
+	}
+
+	_, err = handler.CreateNotification(framework.Context(), &proto.Notification{
+		EventId: "plugin-registry:update-" + upd.Name,
+		Type:    proto.NotificationType_NOTIFICATION_TYPE_INFO,
+		Title:   upd.Name + " updated to " + upd.NewVersion,
+		Message: "The plugin " + upd.Name + " has been updated successfully to version " + upd.NewVersion + ".",
+		Expires: time.Now().Add(time.Minute).UnixNano(),
+	})
+	if err != nil {
+		hclog.L().Error("failed to create update notification", "plugin", upd.Name, "error", err)
 	}
 }
+
+func (handler *NotificationHandler) onInstallProgress(evt manager.InstallProgress) {
+	// we cannot report a percentage if the artifact size is unknown.
+	if evt.Total <= 0 {
+		return
+	}
+
+	percent := evt.Downloaded * 100 / evt.Total
+	if last, ok := handler.progress[evt.Plugin]; ok && last == percent {
+		return
+	}
+	handler.progress[evt.Plugin] = percent
+
+	_, err := handler.CreateNotification(framework.Context(), &proto.Notification{
+		EventId: "plugin-registry:install-progress-" + evt.Plugin,
+		Type:    proto.NotificationType_NOTIFICATION_TYPE_INFO,
+		Title:   "Installing " + evt.Plugin + " " + evt.Version,
+		Message: fmt.Sprintf("Downloading %s %s: %d%%", evt.Plugin, evt.Version, percent),
+	})
+	if err != nil {
+		hclog.L().Error("failed to create install-progress notification", "plugin", evt.Plugin, "error", err)
+	}
+}
+
+func (handler *NotificationHandler) onInstallFinished(evt manager.InstallFinished) {
+	if _, ok := handler.progress[evt.Plugin]; !ok {
+		return
+	}
+	delete(handler.progress, evt.Plugin)
+
+	_, err := handler.CreateNotification(framework.Context(), &proto.Notification{
+		EventId: "plugin-registry:install-progress-" + evt.Plugin,
+		Type:    proto.NotificationType_NOTIFICATION_TYPE_INFO,
+		Expires: time.Now().Add(-time.Second).UnixNano(),
+	})
+	if err != nil {
+		hclog.L().Error("failed to clear install-progress notification", "plugin", evt.Plugin, "error", err)
+	}
+}
+
+func (handler *NotificationHandler) onRollback(rollback structs.Rollback) {
+	_, err := handler.CreateNotification(framework.Context(), &proto.Notification{
+		EventId: "plugin-registry:rollback-" + rollback.Name,
+		Type:    proto.NotificationType_NOTIFICATION_TYPE_ERROR,
+		Title:   rollback.Name + ": update to " + rollback.FailedVersion + " failed",
+		Message: "The plugin " + rollback.Name + " failed to start after updating to version " + rollback.FailedVersion +
+			" and has been rolled back to version " + rollback.RestoredVersion + ".\n\nError: " + rollback.Error,
+		Actions: []*proto.NotificationAction{
+			{
+				Id:   "go-away",
+				Text: "OK",
+			},
+		},
+	})
+	if err != nil {
+		hclog.L().Error("failed to create rollback notification", "plugin", rollback.Name, "error", err)
+	}
+}
+
+// isSnoozedLocked reports whether update notifications for name are
+// snoozed. Callers must hold handler.l.
+func (handler *NotificationHandler) isSnoozedLocked(name string) bool {
+	until, ok := handler.snoozed[name]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(until) {
+		delete(handler.snoozed, name)
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// artifactStatus describes the availability of a resolved artifact URL.
+type artifactStatus string
+
+const (
+	statusResolved    artifactStatus = "resolved"
+	statusAvailable   artifactStatus = "available"
+	statusNotFound    artifactStatus = "not-found"
+	statusMismatched  artifactStatus = "mismatched"
+	statusNoArtifact  artifactStatus = "no-artifact"
+	statusCheckFailed artifactStatus = "error"
+)
+
+const artifactCheckTimeout = 30 * time.Second
+
+type (
+	// artifactInfo holds the result of checking an artifact URL.
+	artifactInfo struct {
+		Status artifactStatus
+
+		// Size is the size of the artifact in bytes or -1 if unknown.
+		Size int64
+
+		// ContentType is the content type reported by the server, if any.
+		ContentType string
+	}
+
+	// artifactTarget is an artifact URL to check along with the plugin
+	// release and platform it belongs to. Checksum may hold the declared
+	// digest of the artifact in the form sha256:<hex> or sha512:<hex>.
+	artifactTarget struct {
+		Plugin   string
+		Version  string
+		Platform string
+		URL      string
+		Checksum string
+	}
+
+	// artifactCheckResult is the result of checking an artifactTarget.
+	artifactCheckResult struct {
+		artifactTarget
+		artifactInfo
+
+		Err error
+	}
+)
+
+// checkArtifactURL checks whether the artifact at rawURL exists. HTTP(S) URLs
+// are checked using a HEAD request and file URLs by looking at the local file
+// system. URLs using other schemes are not checked and reported as resolved.
+//
+// If checksum holds a sha256 or sha512 digest, existing artifacts are
+// downloaded and reported as mismatched if their digest differs. Other
+// checksums, like references to checksum files, are ignored.
+func checkArtifactURL(ctx context.Context, cli *http.Client, rawURL, checksum string) (artifactInfo, error) {
+	info := artifactInfo{
+		Status: statusCheckFailed,
+		Size:   -1,
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return info, fmt.Errorf("failed to parse URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+	case "file":
+		stat, err := os.Stat(u.Path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				info.Status = statusNotFound
+
+				return info, nil
+			}
+
+			return info, err
+		}
+
+		info.Status = statusAvailable
+		info.Size = stat.Size()
+
+		if h, digest := checksumHash(checksum); h != nil {
+			f, err := os.Open(u.Path)
+			if err != nil {
+				return info, err
+			}
+			defer f.Close()
+
+			if _, err := io.Copy(h, f); err != nil {
+				return info, fmt.Errorf("failed to read artifact: %w", err)
+			}
+
+			if hex.EncodeToString(h.Sum(nil)) != digest {
+				info.Status = statusMismatched
+			}
+		}
+
+		return info, nil
+	default:
+		info.Status = statusResolved
+
+		return info, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, artifactCheckTimeout)
+	defer cancel()
+
+	res, err := doArtifactRequest(ctx, cli, http.MethodHead, rawURL)
+	if err == nil && res.StatusCode == http.StatusMethodNotAllowed {
+		// some servers do not support HEAD requests so fall back to a GET
+		// request and only read the response headers.
+		res, err = doArtifactRequest(ctx, cli, http.MethodGet, rawURL)
+	}
+	if err != nil {
+		return info, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		info.Status = statusNotFound
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		info.Status = statusAvailable
+		info.Size = res.ContentLength
+		info.ContentType = res.Header.Get("Content-Type")
+	default:
+		return info, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	if h, digest := checksumHash(checksum); h != nil && info.Status == statusAvailable {
+		if err := downloadArtifact(ctx, cli, rawURL, h); err != nil {
+			return info, err
+		}
+
+		if hex.EncodeToString(h.Sum(nil)) != digest {
+			info.Status = statusMismatched
+		}
+	}
+
+	return info, nil
+}
+
+// checksumHash returns a new hash and the expected lower-case hex digest
+// for a checksum in the form sha256:<hex> or sha512:<hex>. It returns a nil
+// hash for all other checksums.
+func checksumHash(checksum string) (hash.Hash, string) {
+	idx := strings.Index(checksum, ":")
+	if idx < 0 {
+		return nil, ""
+	}
+
+	digest := strings.ToLower(checksum[idx+1:])
+
+	switch checksum[:idx] {
+	case "sha256":
+		return sha256.New(), digest
+	case "sha512":
+		return sha512.New(), digest
+	default:
+		return nil, ""
+	}
+}
+
+// downloadArtifact writes the artifact at rawURL to w.
+func downloadArtifact(ctx context.Context, cli *http.Client, rawURL string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	if _, err := io.Copy(w, res.Body); err != nil {
+		return fmt.Errorf("failed to download artifact: %w", err)
+	}
+
+	return nil
+}
+
+func doArtifactRequest(ctx context.Context, cli *http.Client, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	return res, nil
+}
+
+// checkArtifacts checks all targets using up to concurrency parallel
+// requests. If rateLimit is greater than zero, at most rateLimit requests
+// are started per second. Results are returned in the order of targets.
+func checkArtifacts(ctx context.Context, cli *http.Client, targets []artifactTarget, concurrency int, rateLimit float64) []artifactCheckResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var throttle <-chan time.Time
+	if rateLimit > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rateLimit))
+		defer ticker.Stop()
+
+		throttle = ticker.C
+	}
+
+	results := make([]artifactCheckResult, len(targets))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for idx := range indexes {
+				info, err := checkArtifactURL(ctx, cli, targets[idx].URL, targets[idx].Checksum)
+
+				results[idx] = artifactCheckResult{
+					artifactTarget: targets[idx],
+					artifactInfo:   info,
+					Err:            err,
+				}
+			}
+		}()
+	}
+
+	feed := func() {
+		defer close(indexes)
+
+		for idx := range targets {
+			if throttle != nil {
+				select {
+				case <-throttle:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case indexes <- idx:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	feed()
+
+	wg.Wait()
+
+	// targets that have not been checked due to cancellation
+	for idx := range results {
+		if results[idx].Status == "" {
+			results[idx] = artifactCheckResult{
+				artifactTarget: targets[idx],
+				artifactInfo: artifactInfo{
+					Status: statusCheckFailed,
+					Size:   -1,
+				},
+				Err: ctx.Err(),
+			}
+		}
+	}
+
+	return results
+}
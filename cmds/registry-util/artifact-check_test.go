@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckArtifactURLChecksum(t *testing.T) {
+	content := []byte("plugin binary")
+
+	sum256 := sha256.Sum256(content)
+	sum512 := sha512.Sum512(content)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "plugin")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		checksum string
+		expected artifactStatus
+	}{
+		{name: "no checksum", expected: statusAvailable},
+		{name: "sha256", checksum: "sha256:" + hex.EncodeToString(sum256[:]), expected: statusAvailable},
+		{name: "sha256 upper-case", checksum: "sha256:" + strings.ToUpper(hex.EncodeToString(sum256[:])), expected: statusAvailable},
+		{name: "sha512", checksum: "sha512:" + hex.EncodeToString(sum512[:]), expected: statusAvailable},
+		{name: "sha256 mismatch", checksum: "sha256:" + strings.Repeat("0", 64), expected: statusMismatched},
+		{name: "sha512 mismatch", checksum: "sha512:" + strings.Repeat("0", 128), expected: statusMismatched},
+		{name: "checksum file", checksum: "file:" + srv.URL + "/SHA256SUMS", expected: statusAvailable},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, u := range []string{srv.URL + "/plugin", "file://" + filepath.ToSlash(path)} {
+				info, err := checkArtifactURL(context.Background(), srv.Client(), u, c.checksum)
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %s", u, err)
+				}
+
+				if info.Status != c.expected {
+					t.Errorf("%s: expected status %q but got %q", u, c.expected, info.Status)
+				}
+			}
+		})
+	}
+}
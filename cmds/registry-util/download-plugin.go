@@ -10,10 +10,12 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var downloadPlatform platformFlags
+
 var downloadArtifactUrl = &cobra.Command{
 	Use:   "download-plugin index-file plugin-name",
 	Args:  cobra.ExactArgs(2),
-	Short: "Download the plugin binary for your system architecture",
+	Short: "Download the plugin binary for your system architecture or the one selected by --os and --arch",
 	Run: func(cmd *cobra.Command, args []string) {
 		indexFile := args[0]
 		pluginName := args[1]
@@ -24,7 +26,9 @@ var downloadArtifactUrl = &cobra.Command{
 			os.Exit(1)
 		}
 
-		dst, err := installer.DownloadPlugin(context.Background(), "", plg)
+		bar := newProgressBar()
+		dst, err := installer.DownloadPluginForPlatform(context.Background(), "", plg, downloadPlatform.platform(), bar.Func())
+		bar.Done()
 		if err != nil {
 			hclog.L().Error("failed to download plugin", "error", err)
 			os.Exit(1)
@@ -33,3 +37,7 @@ var downloadArtifactUrl = &cobra.Command{
 		fmt.Println(dst)
 	},
 }
+
+func init() {
+	downloadPlatform.register(downloadArtifactUrl)
+}
@@ -6,25 +6,28 @@ import (
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/ppacher/portmaster-plugin-registry/installer"
+	"github.com/ppacher/portmaster-plugin-registry/registry"
 	"github.com/ppacher/portmaster-plugin-registry/structs"
 	"github.com/spf13/cobra"
 )
 
+var getURLPlatform platformFlags
+
 var getArtifactUrl = &cobra.Command{
 	Use:   "get-url index-file plugin-name",
 	Args:  cobra.ExactArgs(2),
-	Short: "Get the download url for a plugin on your system architecture",
+	Short: "Get the download url for a plugin on your system architecture or the one selected by --os and --arch",
 	Run: func(cmd *cobra.Command, args []string) {
 		indexFile := args[0]
 		pluginName := args[1]
 
-		url, _, err := getDownloadURL(indexFile, pluginName)
+		artifact, err := getDownloadURL(indexFile, pluginName, getURLPlatform.platform())
 		if err != nil {
 			hclog.L().Error(err.Error())
 			os.Exit(1)
 		}
 
-		fmt.Println(url)
+		fmt.Println(artifact.URL)
 	},
 }
 
@@ -36,23 +39,27 @@ func getPluginDesc(indexFile string, pluginName string) (structs.PluginDesc, err
 
 	for _, p := range index.Plugins {
 		if p.Name == pluginName {
-			return p, nil
+			releases, err := registry.PluginReleases(p)
+			if err != nil {
+				return structs.PluginDesc{}, err
+			}
+
+			return releases[0], nil
 		}
 	}
 
 	return structs.PluginDesc{}, fmt.Errorf("failed to find plugin in index")
 }
 
-func getDownloadURL(indexFile string, pluginName string) (string, string, error) {
-	plg, err := getPluginDesc(indexFile, pluginName)
-	if err != nil {
-		return "", "", err
-	}
+func init() {
+	getURLPlatform.register(getArtifactUrl)
+}
 
-	url, artifactFile, err := installer.FindMatchingArtifact(plg)
+func getDownloadURL(indexFile string, pluginName string, platform installer.Platform) (installer.MatchingArtifact, error) {
+	plg, err := getPluginDesc(indexFile, pluginName)
 	if err != nil {
-		return "", "", err
+		return installer.MatchingArtifact{}, err
 	}
 
-	return url, artifactFile, nil
+	return installer.FindArtifactForPlatform(plg, platform)
 }
@@ -5,23 +5,47 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsimple"
 	"github.com/ppacher/portmaster-plugin-registry/installer"
+	"github.com/ppacher/portmaster-plugin-registry/registry"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
 	"github.com/safing/portmaster/plugin/shared"
 	"github.com/spf13/cobra"
 )
 
 var (
-	installTarget string
-	pluginsConfig string
+	installTarget       string
+	pluginsConfig       string
+	installRepositories string
 )
 
 var installCommand = &cobra.Command{
-	Use:   "install index-file plugin-name",
+	Use:   "install [index-file] [repo/]plugin-name",
 	Short: "Install a plugin",
+	Long: "Install a plugin from index-file or, if --repositories is set, from the\n" +
+		"configured repositories. Use repo/plugin-name to install the plugin from a\n" +
+		"specific repository instead of the one with the highest priority.",
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
-		plg, err := getPluginDesc(args[0], args[1])
+		var (
+			plg structs.PluginDesc
+			err error
+		)
+
+		switch {
+		case installRepositories != "" && len(args) == 1:
+			plg, err = getPluginFromRepositories(cmd.Context(), installRepositories, args[0])
+		case installRepositories == "" && len(args) == 2:
+			plg, err = getPluginDesc(args[0], args[1])
+		default:
+			err = fmt.Errorf("either specify an index-file or --repositories")
+		}
+
 		if err != nil {
 			hclog.L().Error(err.Error())
 			os.Exit(1)
@@ -31,7 +55,9 @@ var installCommand = &cobra.Command{
 			TargetDirectory: installTarget,
 		}
 
-		path, err := inst.InstallPlugin(context.Background(), plg)
+		bar := newProgressBar()
+		path, err := inst.InstallPlugin(cmd.Context(), plg, bar.Func())
+		bar.Done()
 		if err != nil {
 			hclog.L().Error("failed to install plugin", "error", err)
 			os.Exit(1)
@@ -55,6 +81,45 @@ var installCommand = &cobra.Command{
 func init() {
 	installCommand.Flags().StringVar(&installTarget, "target", "/opt/safing/portmaster/plugins", "The path where the plugin binary should be installed")
 	installCommand.Flags().StringVar(&pluginsConfig, "config", "/opt/safing/portmaster/plugins.json", "The path to the portmaster plugins.json")
+	installCommand.Flags().StringVar(&installRepositories, "repositories", "", "The path to a repositories.hcl file to install plugins from")
+}
+
+// getPluginFromRepositories fetches all repositories configured in
+// repositoriesFile and returns the plugin name, which may be in the form of
+// repo/plugin.
+func getPluginFromRepositories(ctx context.Context, repositoriesFile string, name string) (structs.PluginDesc, error) {
+	var cfg struct {
+		Repositories []structs.Repository `hcl:"repository,block"`
+		Remain       hcl.Body             `hcl:",remain"`
+	}
+
+	if err := hclsimple.DecodeFile(repositoriesFile, nil, &cfg); err != nil {
+		return structs.PluginDesc{}, fmt.Errorf("failed to read repositories: %w", err)
+	}
+
+	reg := registry.NewRegistry("")
+	for _, repo := range cfg.Repositories {
+		if !strings.Contains(repo.URL, "://") && !filepath.IsAbs(repo.URL) {
+			repo.URL = filepath.Join(filepath.Dir(repositoriesFile), repo.URL)
+		}
+
+		if err := reg.AddRepository(repo); err != nil {
+			return structs.PluginDesc{}, fmt.Errorf("repository %s: %w", repo.Name, err)
+		}
+	}
+
+	// failed repositories are not fatal as long as the plugin can be
+	// found in any of the others.
+	if err := reg.Fetch(ctx); err != nil {
+		hclog.L().Warn("failed to fetch repositories", "error", err)
+	}
+
+	plg, ok := reg.ByName(name)
+	if !ok {
+		return structs.PluginDesc{}, fmt.Errorf("failed to find plugin %s", name)
+	}
+
+	return plg, nil
 }
 
 func updatePluginsConfig(pluginJson, pluginDir string, cfg shared.PluginConfig) error {
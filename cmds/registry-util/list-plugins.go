@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/hashicorp/go-hclog"
+	"github.com/ppacher/portmaster-plugin-registry/registry"
 	"github.com/spf13/cobra"
 )
 
@@ -25,7 +27,18 @@ var listPluginsCommand = &cobra.Command{
 		description := color.New(color.Italic).Sprint
 
 		for _, plg := range index.Plugins {
-			fmt.Printf(bullet+" %s %s\n", pluginHeader(plg.Name), description(plg.Version))
+			releases, err := registry.PluginReleases(plg)
+			if err != nil {
+				hclog.L().Error("failed to get plugin releases", "plugin", plg.Name, "error", err)
+				os.Exit(1)
+			}
+
+			versions := make([]string, len(releases))
+			for idx, release := range releases {
+				versions[idx] = release.Version
+			}
+
+			fmt.Printf(bullet+" %s %s\n", pluginHeader(plg.Name), description(strings.Join(versions, ", ")))
 			fmt.Println("  " + description(plg.Description))
 			fmt.Println("  by " + description(plg.Author))
 
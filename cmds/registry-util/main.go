@@ -18,6 +18,10 @@ func main() {
 		downloadArtifactUrl,
 		installCommand,
 		listPluginsCommand,
+		signIndexCommand,
+		mirrorCommand,
+		searchCommand,
+		resolveAllCommand,
 	)
 
 	if err := root.Execute(); err != nil {
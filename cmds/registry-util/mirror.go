@@ -0,0 +1,302 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/ppacher/portmaster-plugin-registry/installer"
+	"github.com/ppacher/portmaster-plugin-registry/registry"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mirrorPlatforms []string
+	mirrorTarball   string
+)
+
+var mirrorCommand = &cobra.Command{
+	Use:   "mirror index-file output-directory",
+	Short: "Create a self-contained mirror of a repository index and all plugin artifacts",
+	Long: `Create a self-contained mirror of a repository index and all plugin artifacts.
+
+The mirror contains an index.json file that references all artifacts using paths
+relative to the index file. The output directory can be used as a repository
+by configuring a file:// repository URL that points to the index.json file.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		index, err := loadAndVerifyIndex(args[0])
+		if err != nil {
+			hclog.L().Error("failed to get repository index", "error", err)
+			os.Exit(1)
+		}
+
+		outputDir := args[1]
+
+		mirror, err := mirrorIndex(cmd.Context(), index, outputDir, mirrorPlatforms)
+		if err != nil {
+			hclog.L().Error("failed to mirror repository", "error", err)
+			os.Exit(1)
+		}
+
+		blob, err := json.MarshalIndent(mirror, "", "    ")
+		if err != nil {
+			hclog.L().Error("failed to marshal index", "error", err)
+			os.Exit(1)
+		}
+
+		indexFile := filepath.Join(outputDir, "index.json")
+		if err := os.WriteFile(indexFile, blob, 0644); err != nil {
+			hclog.L().Error("failed to write index", "error", err)
+			os.Exit(1)
+		}
+
+		hclog.L().Info("mirror created successfully", "index", indexFile)
+
+		if mirrorTarball != "" {
+			if err := writeTarball(mirrorTarball, outputDir); err != nil {
+				hclog.L().Error("failed to create tarball", "error", err)
+				os.Exit(1)
+			}
+
+			hclog.L().Info("tarball created successfully", "path", mirrorTarball)
+		}
+	},
+}
+
+func init() {
+	mirrorCommand.Flags().StringSliceVar(&mirrorPlatforms, "platform", []string{runtime.GOOS + "/" + runtime.GOARCH}, "The os/arch[/variant] platforms to mirror artifacts for. May be specified multiple times")
+	mirrorCommand.Flags().StringVar(&mirrorTarball, "tarball", "", "Create a .tar.gz archive of the mirror at the given path")
+}
+
+// mirrorIndex downloads all artifacts of all plugin releases in index for the
+// selected platforms into outputDir and returns a new index that references the
+// downloaded artifacts using relative paths.
+func mirrorIndex(ctx context.Context, index *structs.RepositoryIndex, outputDir string, platforms []string) (*structs.RepositoryIndex, error) {
+	mirror := &structs.RepositoryIndex{
+		Meta: structs.IndexMeta{
+			Version:     registry.IndexVersion12,
+			Description: index.Meta.Description,
+		},
+	}
+
+	for _, plg := range index.Plugins {
+		releases, err := registry.PluginReleases(plg)
+		if err != nil {
+			return nil, err
+		}
+
+		mirrorPlg := plg
+		mirrorPlg.Version = ""
+		mirrorPlg.ArtifactTemplate = ""
+		mirrorPlg.ArchiveFile = ""
+		mirrorPlg.ArchiveFormat = ""
+		mirrorPlg.Checksums = ""
+		mirrorPlg.OSNames = nil
+		mirrorPlg.ArchNames = nil
+		mirrorPlg.Extensions = nil
+		mirrorPlg.Artifacts = nil
+		mirrorPlg.Downloads = nil
+		mirrorPlg.Channel = ""
+		mirrorPlg.Releases = nil
+
+		for _, release := range releases {
+			downloads, err := mirrorRelease(ctx, release, outputDir, platforms)
+			if err != nil {
+				return nil, fmt.Errorf("plugin %s: release %s: %w", plg.Name, release.Version, err)
+			}
+
+			if len(downloads) == 0 {
+				hclog.L().Warn("no artifacts for selected platforms, skipping release", "plugin", plg.Name, "version", release.Version)
+
+				continue
+			}
+
+			privileged := release.Privileged
+			mirrorPlg.Releases = append(mirrorPlg.Releases, structs.Release{
+				Version:     release.Version,
+				Downloads:   downloads,
+				PluginTypes: release.PluginTypes,
+				Privileged:  &privileged,
+				Channel:     release.Channel,
+			})
+		}
+
+		if len(mirrorPlg.Releases) > 0 {
+			mirror.Plugins = append(mirror.Plugins, mirrorPlg)
+		}
+	}
+
+	return mirror, nil
+}
+
+// mirrorRelease downloads the artifacts of release for all platforms into
+// outputDir. At most one download is returned per os/arch/variant/libc/min_os
+// combination as required by registry.ValidateIndex.
+func mirrorRelease(ctx context.Context, release structs.PluginDesc, outputDir string, platforms []string) ([]structs.Download, error) {
+	var downloads []structs.Download
+
+	seen := make(map[structs.Download]struct{})
+
+	for _, p := range platforms {
+		platform, err := installer.ParsePlatform(p)
+		if err != nil {
+			return nil, err
+		}
+
+		artifact, err := installer.FindArtifactForPlatform(release, platform)
+		if err != nil {
+			if errors.Is(err, installer.ErrNoMatchingArtifact) {
+				hclog.L().Warn("no artifact available", "plugin", release.Name, "version", release.Version, "platform", platform)
+
+				continue
+			}
+
+			return nil, err
+		}
+
+		// artifacts rendered from a template or defined in legacy artifact
+		// blocks don't carry a variant so the one of the platform is used.
+		variant := artifact.Variant
+		if variant == "" {
+			variant = platform.Variant
+		}
+
+		key := structs.Download{
+			OS:           platform.OS,
+			Arch:         platform.Arch,
+			Variant:      variant,
+			Libc:         artifact.Libc,
+			MinOSVersion: artifact.MinOSVersion,
+		}
+		if _, ok := seen[key]; ok {
+			hclog.L().Debug("artifact already mirrored, skipping platform", "plugin", release.Name, "version", release.Version, "platform", platform)
+
+			continue
+		}
+
+		u, err := url.Parse(artifact.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse artifact URL: %w", err)
+		}
+
+		platformDir := strings.ReplaceAll(platform.String(), "/", "_")
+		relPath := path.Join("artifacts", release.Name, release.Version, platformDir, path.Base(u.Path))
+		dst := filepath.Join(outputDir, filepath.FromSlash(relPath))
+
+		hclog.L().Info("downloading artifact", "plugin", release.Name, "version", release.Version, "platform", platform, "url", artifact.URL)
+
+		// artifact templates may render URLs for platforms that have not
+		// been published so we only warn about failed downloads.
+		if err := installer.DownloadArtifact(ctx, artifact, dst); err != nil {
+			hclog.L().Warn("failed to download artifact, skipping platform", "plugin", release.Name, "version", release.Version, "platform", platform, "error", err)
+
+			continue
+		}
+
+		digest, err := sha256File(dst)
+		if err != nil {
+			return nil, err
+		}
+
+		download := key
+		download.URL = "./" + relPath
+		download.ArchiveFile = artifact.ArchiveFile
+		download.Format = artifact.Format
+		download.SHA256 = digest
+
+		downloads = append(downloads, download)
+		seen[key] = struct{}{}
+	}
+
+	return downloads, nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeTarball writes a gzip compressed tar archive of all files in dir to target.
+func writeTarball(target string, dir string) error {
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		_, err = io.Copy(tw, src)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
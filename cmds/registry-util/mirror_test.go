@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ppacher/portmaster-plugin-registry/registry"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+func TestMirrorIndexArmVariants(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	index := &structs.RepositoryIndex{
+		Meta: structs.IndexMeta{
+			Version: registry.IndexVersion12,
+		},
+		Plugins: []structs.PluginDesc{
+			{
+				Name:             "template",
+				Version:          "1.0.0",
+				ArtifactTemplate: srv.URL + "/{{plugin_name}}_{{os}}_{{arch}}{{variant}}.tar.gz",
+			},
+			{
+				Name:    "legacy",
+				Version: "1.0.0",
+				Artifacts: []structs.Artifact{
+					{OS: "linux", ARM: srv.URL + "/legacy_linux_arm.tar.gz"},
+				},
+			},
+			{
+				Name:    "downloads",
+				Version: "1.0.0",
+				Downloads: []structs.Download{
+					{OS: "linux", Arch: "arm", Variant: "v6", URL: srv.URL + "/downloads_linux_armv6.tar.gz"},
+					{OS: "linux", Arch: "arm", Variant: "v7", URL: srv.URL + "/downloads_linux_armv7.tar.gz"},
+				},
+			},
+		},
+	}
+
+	// linux/arm/v7 is listed twice on purpose.
+	platforms := []string{"linux/arm/v6", "linux/arm/v7", "linux/arm/v7"}
+
+	mirror, err := mirrorIndex(context.Background(), index, t.TempDir(), platforms)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := registry.ValidateIndex(mirror); err != nil {
+		t.Fatalf("mirrored index is invalid: %s", err)
+	}
+
+	if len(mirror.Plugins) != len(index.Plugins) {
+		t.Fatalf("expected %d plugins but got %d", len(index.Plugins), len(mirror.Plugins))
+	}
+
+	for _, plg := range mirror.Plugins {
+		t.Run(plg.Name, func(t *testing.T) {
+			if len(plg.Releases) != 1 {
+				t.Fatalf("expected a single release but got %d", len(plg.Releases))
+			}
+
+			var variants []string
+			for _, download := range plg.Releases[0].Downloads {
+				variants = append(variants, download.Variant)
+			}
+
+			if expected := []string{"v6", "v7"}; !reflect.DeepEqual(variants, expected) {
+				t.Errorf("expected variants %v but got %v", expected, variants)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/ppacher/portmaster-plugin-registry/installer"
+	"github.com/spf13/cobra"
+)
+
+// platformFlags holds the --os, --arch and --variant flags of commands that
+// resolve artifacts for a platform other than the current system.
+type platformFlags struct {
+	os      string
+	arch    string
+	variant string
+}
+
+func (flags *platformFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&flags.os, "os", "", "The operating system to resolve the artifact for. Defaults to the current system")
+	cmd.Flags().StringVar(&flags.arch, "arch", "", "The architecture to resolve the artifact for. Defaults to the current system")
+	cmd.Flags().StringVar(&flags.variant, "variant", "", "The architecture variant to resolve the artifact for, like v7 for arm")
+}
+
+// platform returns the platform selected by the flags. If neither --os nor
+// --arch are set the current system is used.
+func (flags *platformFlags) platform() installer.Platform {
+	current := installer.CurrentPlatform()
+
+	if flags.os == "" && flags.arch == "" {
+		if flags.variant != "" {
+			current.Variant = flags.variant
+		}
+
+		return current
+	}
+
+	// libc and OS version are only known for the current system so they
+	// are not used for other platforms.
+	platform := installer.Platform{
+		OS:      flags.os,
+		Arch:    flags.arch,
+		Variant: flags.variant,
+	}
+
+	if platform.OS == "" {
+		platform.OS = current.OS
+	}
+
+	if platform.Arch == "" {
+		platform.Arch = current.Arch
+	}
+
+	return platform
+}
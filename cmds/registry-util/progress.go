@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ppacher/portmaster-plugin-registry/installer"
+)
+
+const progressBarWidth = 40
+
+// progressBar renders the download progress of a plugin artifact on a
+// terminal.
+type progressBar struct {
+	out        io.Writer
+	lastRender time.Time
+	rendered   bool
+}
+
+// newProgressBar returns a progress bar that renders to stderr. It returns nil
+// if stderr is not a terminal.
+func newProgressBar() *progressBar {
+	stat, err := os.Stderr.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+
+	return &progressBar{
+		out: os.Stderr,
+	}
+}
+
+// Func returns the installer.ProgressFunc that updates the progress bar. It's
+// safe to call Func on a nil progress bar.
+func (bar *progressBar) Func() installer.ProgressFunc {
+	if bar == nil {
+		return nil
+	}
+
+	return bar.update
+}
+
+// Done finishes the progress bar so further output starts on a new line.
+func (bar *progressBar) Done() {
+	if bar == nil || !bar.rendered {
+		return
+	}
+
+	fmt.Fprintln(bar.out)
+}
+
+func (bar *progressBar) update(downloaded, total int64) {
+	if downloaded != total && time.Since(bar.lastRender) < 100*time.Millisecond {
+		return
+	}
+	bar.lastRender = time.Now()
+	bar.rendered = true
+
+	if total <= 0 {
+		fmt.Fprintf(bar.out, "\r%s downloaded", formatBytes(downloaded))
+
+		return
+	}
+
+	filled := int(downloaded * progressBarWidth / total)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+
+	fmt.Fprintf(bar.out, "\r[%s%s] %3d%% %s / %s",
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", progressBarWidth-filled),
+		downloaded*100/total,
+		formatBytes(downloaded),
+		formatBytes(total),
+	)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for i := n / unit; i >= unit; i /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
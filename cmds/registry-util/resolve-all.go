@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/ppacher/portmaster-plugin-registry/installer"
+	"github.com/ppacher/portmaster-plugin-registry/registry"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+	"github.com/spf13/cobra"
+)
+
+// supportedPlatforms are the platforms artifacts are resolved for if
+// --platform is not set.
+var supportedPlatforms = []string{
+	"linux/amd64",
+	"linux/arm64",
+	"linux/arm",
+	"linux/386",
+	"windows/amd64",
+	"windows/arm64",
+	"darwin/amd64",
+	"darwin/arm64",
+}
+
+var (
+	resolvePlatforms []string
+	resolveOutput    string
+	resolveCheck     bool
+)
+
+// resolvedArtifact is the artifact of a plugin resolved for a platform.
+type resolvedArtifact struct {
+	Plugin   string         `json:"plugin"`
+	Version  string         `json:"version"`
+	Platform string         `json:"platform"`
+	URL      string         `json:"url,omitempty"`
+	Status   artifactStatus `json:"status"`
+	Error    string         `json:"error,omitempty"`
+}
+
+var resolveAllCommand = &cobra.Command{
+	Use:   "resolve-all index-file",
+	Short: "Resolve the artifact URL of every plugin for all platforms",
+	Long: `Resolve the artifact URL of the latest release of every plugin in the
+index for all platforms.
+
+If --check is set, each resolved URL is checked for existence and the command
+exits with a non-zero status if any artifact is missing or cannot be checked.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if resolveOutput != "table" && resolveOutput != "json" {
+			hclog.L().Error("unsupported output format", "format", resolveOutput)
+			os.Exit(1)
+		}
+
+		index, err := loadAndVerifyIndex(args[0])
+		if err != nil {
+			hclog.L().Error("failed to get repository index", "error", err)
+			os.Exit(1)
+		}
+
+		platforms := make([]installer.Platform, len(resolvePlatforms))
+		for idx, p := range resolvePlatforms {
+			platforms[idx], err = installer.ParsePlatform(p)
+			if err != nil {
+				hclog.L().Error(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		results, failed, err := resolveArtifacts(cmd.Context(), &http.Client{}, index, platforms, resolveCheck)
+		if err != nil {
+			hclog.L().Error(err.Error())
+			os.Exit(1)
+		}
+
+		if err := printResolvedArtifacts(results, resolveOutput); err != nil {
+			hclog.L().Error(err.Error())
+			os.Exit(1)
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// resolveArtifacts resolves the artifact of the latest release of each plugin
+// in index for all platforms. If check is set, the resolved URLs are checked
+// for existence. The returned bool is true if any artifact is missing or could
+// not be checked.
+func resolveArtifacts(ctx context.Context, cli *http.Client, index *structs.RepositoryIndex, platforms []installer.Platform, check bool) ([]resolvedArtifact, bool, error) {
+	var (
+		results []resolvedArtifact
+		failed  bool
+	)
+
+	for _, plg := range index.Plugins {
+		releases, err := registry.PluginReleases(plg)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get releases of plugin %s: %w", plg.Name, err)
+		}
+
+		latest := releases[0]
+
+		for _, platform := range platforms {
+			result := resolvedArtifact{
+				Plugin:   latest.Name,
+				Version:  latest.Version,
+				Platform: platform.String(),
+				Status:   statusResolved,
+			}
+
+			artifact, err := installer.FindArtifactForPlatform(latest, platform)
+			switch {
+			case errors.Is(err, installer.ErrNoMatchingArtifact):
+				result.Status = statusNoArtifact
+			case err != nil:
+				result.Status = statusCheckFailed
+				result.Error = err.Error()
+			default:
+				result.URL = artifact.URL
+
+				if check {
+					info, err := checkArtifactURL(ctx, cli, artifact.URL, "")
+					result.Status = info.Status
+					if err != nil {
+						result.Error = err.Error()
+					}
+				}
+			}
+
+			if result.Status == statusNotFound || result.Status == statusCheckFailed {
+				failed = true
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	return results, failed, nil
+}
+
+func printResolvedArtifacts(results []resolvedArtifact, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+
+		if results == nil {
+			results = []resolvedArtifact{}
+		}
+
+		return enc.Encode(results)
+
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PLUGIN\tVERSION\tPLATFORM\tSTATUS\tURL")
+
+		for _, result := range results {
+			location := result.URL
+			if result.Error != "" {
+				location += " (" + result.Error + ")"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.Plugin, result.Version, result.Platform, result.Status, location)
+		}
+
+		return w.Flush()
+
+	default:
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+}
+
+func init() {
+	resolveAllCommand.Flags().StringSliceVar(&resolvePlatforms, "platform", supportedPlatforms, "The os/arch[/variant] platforms to resolve artifacts for. May be specified multiple times")
+	resolveAllCommand.Flags().StringVarP(&resolveOutput, "output", "o", "table", "The output format, either table or json")
+	resolveAllCommand.Flags().BoolVar(&resolveCheck, "check", false, "Check that every resolved artifact URL exists")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ppacher/portmaster-plugin-registry/installer"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+func TestResolveArtifactsFlagsMissingArtifacts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.0.0/test_linux_amd64.tar.gz", "/v1.0.0/test_linux_arm64.tar.gz":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	index := &structs.RepositoryIndex{
+		Plugins: []structs.PluginDesc{
+			{
+				Name:             "test",
+				Version:          "v1.0.0",
+				ArtifactTemplate: srv.URL + "/{{version}}/{{plugin_name}}_{{os}}_{{arch}}.{{ext}}",
+			},
+			{
+				Name:    "no-artifact",
+				Version: "v1.0.0",
+				Downloads: []structs.Download{
+					{OS: "linux", Arch: "amd64", URL: srv.URL + "/v1.0.0/test_linux_amd64.tar.gz"},
+				},
+			},
+		},
+	}
+
+	var platforms []installer.Platform
+	for _, p := range []string{"linux/amd64", "linux/arm64", "windows/amd64"} {
+		platform, err := installer.ParsePlatform(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		platforms = append(platforms, platform)
+	}
+
+	expected := map[string]artifactStatus{
+		"test linux/amd64":          statusAvailable,
+		"test linux/arm64":          statusAvailable,
+		"test windows/amd64":        statusNotFound,
+		"no-artifact linux/amd64":   statusAvailable,
+		"no-artifact linux/arm64":   statusNoArtifact,
+		"no-artifact windows/amd64": statusNoArtifact,
+	}
+
+	t.Run("check", func(t *testing.T) {
+		results, failed, err := resolveArtifacts(context.Background(), srv.Client(), index, platforms, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !failed {
+			t.Errorf("expected missing artifacts to be reported as failure")
+		}
+
+		if len(results) != len(expected) {
+			t.Fatalf("expected %d results but got %d", len(expected), len(results))
+		}
+
+		for _, result := range results {
+			key := result.Plugin + " " + result.Platform
+			if result.Status != expected[key] {
+				t.Errorf("%s: expected status %q but got %q (url=%s, error=%s)", key, expected[key], result.Status, result.URL, result.Error)
+			}
+		}
+	})
+
+	t.Run("no check", func(t *testing.T) {
+		results, failed, err := resolveArtifacts(context.Background(), srv.Client(), index, platforms, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if failed {
+			t.Errorf("expected no failure without checking artifacts")
+		}
+
+		for _, result := range results {
+			if result.Status == statusNotFound || result.Status == statusAvailable {
+				t.Errorf("%s %s: artifact checked although check is disabled", result.Plugin, result.Platform)
+			}
+		}
+	})
+}
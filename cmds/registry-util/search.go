@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/hashicorp/go-hclog"
+	"github.com/ppacher/portmaster-plugin-registry/registry"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+	"github.com/spf13/cobra"
+)
+
+var searchCommand = &cobra.Command{
+	Use:   "search index-file query...",
+	Short: "Search plugins in a repository index",
+	Long: "Search plugins in a repository index.\n\n" +
+		"The query consists of free-text terms and the facets type:, tag: and license:,\n" +
+		"for example: registry-util search index.hcl dns type:resolver license:MIT",
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		query, err := registry.ParseQuery(strings.Join(args[1:], " "))
+		if err != nil {
+			hclog.L().Error(err.Error())
+			os.Exit(1)
+		}
+
+		index, err := loadAndVerifyIndex(args[0])
+		if err != nil {
+			hclog.L().Error("failed to get repository index", "error", err)
+			os.Exit(1)
+		}
+
+		plugins := make([]structs.PluginDesc, 0, len(index.Plugins))
+		for _, plg := range index.Plugins {
+			releases, err := registry.PluginReleases(plg)
+			if err != nil {
+				hclog.L().Error("failed to get plugin releases", "plugin", plg.Name, "error", err)
+				os.Exit(1)
+			}
+
+			plugins = append(plugins, releases[0])
+		}
+
+		results := registry.SearchPlugins(plugins, query)
+		if len(results) == 0 {
+			fmt.Println("no plugins found")
+
+			return
+		}
+
+		bullet := color.New(color.FgGreen).Sprint("•")
+		pluginHeader := color.New(color.Bold, color.FgHiWhite).Sprint
+		description := color.New(color.Italic).Sprint
+
+		for _, res := range results {
+			fmt.Printf(bullet+" %s %s\n", pluginHeader(res.Plugin.Name), description(res.Plugin.Version))
+			fmt.Println("  " + description(res.Plugin.Description))
+
+			if len(res.Plugin.Tags) > 0 {
+				fmt.Println("  tags: " + description(strings.Join(res.Plugin.Tags, ", ")))
+			}
+
+			fmt.Println()
+		}
+	},
+}
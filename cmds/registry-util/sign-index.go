@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/ppacher/portmaster-plugin-registry/registry"
+	"github.com/spf13/cobra"
+)
+
+var (
+	signingKeyFile string
+	signatureFile  string
+)
+
+var signIndexCommand = &cobra.Command{
+	Use:   "sign-index index-file",
+	Short: "Create a detached signature for a repository index file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		indexFile := args[0]
+
+		// make sure we never sign an invalid index file.
+		if _, err := loadAndVerifyIndex(indexFile); err != nil {
+			hclog.L().Error("invalid repository index", "error", err)
+			os.Exit(1)
+		}
+
+		privateKey, err := os.ReadFile(signingKeyFile)
+		if err != nil {
+			hclog.L().Error("failed to read private key", "error", err)
+			os.Exit(1)
+		}
+
+		blob, err := os.ReadFile(indexFile)
+		if err != nil {
+			hclog.L().Error("failed to read index file", "error", err)
+			os.Exit(1)
+		}
+
+		sig, err := registry.SignIndex(string(privateKey), blob)
+		if err != nil {
+			hclog.L().Error("failed to sign index file", "error", err)
+			os.Exit(1)
+		}
+
+		target := signatureFile
+		if target == "" {
+			target = indexFile + ".sig"
+		}
+
+		if err := os.WriteFile(target, []byte(sig+"\n"), 0644); err != nil {
+			hclog.L().Error("failed to write signature", "error", err)
+			os.Exit(1)
+		}
+
+		hclog.L().Info("index file signed successfully", "signature", target)
+	},
+}
+
+var generateKeyCommand = &cobra.Command{
+	Use:   "generate-key private-key-file",
+	Short: "Generate a new key pair for signing repository index files",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := os.Stat(args[0]); err == nil {
+			hclog.L().Error("refusing to overwrite existing private key", "path", args[0])
+			os.Exit(1)
+		}
+
+		publicKey, privateKey, err := registry.GenerateKey()
+		if err != nil {
+			hclog.L().Error("failed to generate key pair", "error", err)
+			os.Exit(1)
+		}
+
+		if err := os.WriteFile(args[0], []byte(privateKey+"\n"), 0600); err != nil {
+			hclog.L().Error("failed to write private key", "error", err)
+			os.Exit(1)
+		}
+
+		// print the public key so it can be added to the trusted_keys of
+		// a repository configuration.
+		fmt.Println(publicKey)
+	},
+}
+
+var publicKeyCommand = &cobra.Command{
+	Use:   "public-key private-key-file",
+	Short: "Print the public key for a private signing key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		privateKey, err := os.ReadFile(args[0])
+		if err != nil {
+			hclog.L().Error("failed to read private key", "error", err)
+			os.Exit(1)
+		}
+
+		publicKey, err := registry.PublicKey(string(privateKey))
+		if err != nil {
+			hclog.L().Error("invalid private key", "error", err)
+			os.Exit(1)
+		}
+
+		fmt.Println(publicKey)
+	},
+}
+
+func init() {
+	signIndexCommand.Flags().StringVar(&signingKeyFile, "key", "", "The path to the private signing key")
+	signIndexCommand.Flags().StringVar(&signatureFile, "output", "", "The path of the signature file. Defaults to the index file with .sig appended")
+	_ = signIndexCommand.MarkFlagRequired("key")
+
+	signIndexCommand.AddCommand(
+		generateKeyCommand,
+		publicKeyCommand,
+	)
+}
@@ -3,25 +3,57 @@ package main
 import (
 	"context"
 	"fmt"
+	"mime"
+	"net/http"
 	"os"
 
 	"github.com/hashicorp/go-getter/v2"
 	"github.com/hashicorp/go-hclog"
+	"github.com/ppacher/portmaster-plugin-registry/installer"
 	"github.com/ppacher/portmaster-plugin-registry/registry"
 	"github.com/ppacher/portmaster-plugin-registry/structs"
 	"github.com/spf13/cobra"
 )
 
+var (
+	checkIndexArtifacts bool
+	checkPlatforms      []string
+	checkConcurrency    int
+	checkRateLimit      float64
+	maxArtifactSize     int64
+)
+
 var verifyIndexCommand = &cobra.Command{
 	Use: "verify-index [path] [path...]",
+	Long: `Verify the structure of one or more repository indexes.
+
+If --check-artifacts is set, the artifact URLs of all plugin releases are
+checked as well. Explicit artifacts are checked as defined while artifact
+templates are rendered for all platforms selected by --platform. Artifacts
+that are missing, do not look like an artifact or exceed --max-artifact-size
+are reported. Artifacts with a declared sha256 or sha512 digest are
+downloaded and reported if their digest does not match.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		hasErrors := false
 
 		for _, p := range args {
-			_, err := loadAndVerifyIndex(p)
+			index, warnings, err := loadIndex(p)
 			if err != nil {
 				hclog.L().Error(p, "error", err)
 				hasErrors = true
+
+				continue
+			}
+
+			for _, warning := range warnings {
+				hclog.L().Warn(p, "warning", warning)
+			}
+
+			if checkIndexArtifacts {
+				if err := verifyArtifacts(cmd.Context(), index); err != nil {
+					hclog.L().Error(p, "error", err)
+					hasErrors = true
+				}
 			}
 		}
 
@@ -32,14 +64,22 @@ var verifyIndexCommand = &cobra.Command{
 }
 
 func loadAndVerifyIndex(path string) (*structs.RepositoryIndex, error) {
+	index, _, err := loadIndex(path)
+
+	return index, err
+}
+
+// loadIndex loads, validates and resolves the index at path. It returns the
+// validation warnings of the index.
+func loadIndex(path string) (*structs.RepositoryIndex, []string, error) {
 	pwd, err := os.Getwd()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	tempDir, err := os.MkdirTemp("", "*")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer os.RemoveAll(tempDir)
 
@@ -49,22 +89,168 @@ func loadAndVerifyIndex(path string) (*structs.RepositoryIndex, error) {
 		Pwd: pwd,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get repository index: %w", err)
+		return nil, nil, fmt.Errorf("failed to get repository index: %w", err)
 	}
 
 	f, err := os.Open(file.Dst)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open index file: %w", err)
+		return nil, nil, fmt.Errorf("failed to open index file: %w", err)
 	}
 
 	index, err := registry.DecodeIndex(path, f)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode index file: %w", err)
+		return nil, nil, fmt.Errorf("failed to decode index file: %w", err)
+	}
+
+	warnings, err := registry.ValidateIndex(index)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode index file: %w", err)
+	}
+
+	if err := registry.ResolveRelativeURLs(path, index); err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve relative URLs: %w", err)
+	}
+
+	return index, warnings, nil
+}
+
+// verifyArtifacts checks that all artifact URLs of index exist and logs all
+// problems found.
+func verifyArtifacts(ctx context.Context, index *structs.RepositoryIndex) error {
+	platforms := make([]installer.Platform, len(checkPlatforms))
+	for idx, p := range checkPlatforms {
+		var err error
+		platforms[idx], err = installer.ParsePlatform(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	targets, err := collectArtifactTargets(index, platforms)
+	if err != nil {
+		return err
+	}
+
+	hclog.L().Info("checking artifacts", "count", len(targets))
+
+	problems := 0
+	for _, res := range checkArtifacts(ctx, &http.Client{}, targets, checkConcurrency, checkRateLimit) {
+		msg := ""
+
+		switch {
+		case res.Err != nil:
+			msg = "failed to check artifact: " + res.Err.Error()
+		case res.Status == statusNotFound:
+			msg = "artifact not found"
+		case res.Status == statusMismatched:
+			msg = "artifact does not match the declared checksum"
+		case res.Status == statusAvailable && isWebPage(res.ContentType):
+			msg = "artifact URL returns a web page, content-type " + res.ContentType
+		case res.Status == statusAvailable && maxArtifactSize > 0 && res.Size > maxArtifactSize:
+			msg = fmt.Sprintf("artifact size %s exceeds maximum of %s", formatBytes(res.Size), formatBytes(maxArtifactSize))
+		default:
+			continue
+		}
+
+		hclog.L().Error(msg, "plugin", res.Plugin, "version", res.Version, "platform", res.Platform, "url", res.URL)
+		problems++
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d artifact problems", problems)
 	}
 
-	if err := registry.ValidateIndex(index); err != nil {
-		return nil, fmt.Errorf("failed to decode index file: %w", err)
+	return nil
+}
+
+// collectArtifactTargets returns the artifact URLs of all plugin releases in
+// index. Artifact templates are rendered for each of platforms. URLs used by
+// multiple releases or platforms are returned only once.
+func collectArtifactTargets(index *structs.RepositoryIndex, platforms []installer.Platform) ([]artifactTarget, error) {
+	var (
+		targets []artifactTarget
+		seen    = make(map[string]struct{})
+	)
+
+	add := func(plg structs.PluginDesc, platform, url, checksum string) {
+		if _, ok := seen[url]; ok {
+			return
+		}
+		seen[url] = struct{}{}
+
+		targets = append(targets, artifactTarget{
+			Plugin:   plg.Name,
+			Version:  plg.Version,
+			Platform: platform,
+			URL:      url,
+			Checksum: checksum,
+		})
 	}
 
-	return index, nil
+	for _, plg := range index.Plugins {
+		releases, err := registry.PluginReleases(plg)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", plg.Name, err)
+		}
+
+		for _, release := range releases {
+			for _, download := range installer.PluginDownloads(release) {
+				platform := installer.Platform{
+					OS:      download.OS,
+					Arch:    download.Arch,
+					Variant: download.Variant,
+				}
+
+				var checksum string
+				switch {
+				case download.SHA512 != "":
+					checksum = "sha512:" + download.SHA512
+				case download.SHA256 != "":
+					checksum = "sha256:" + download.SHA256
+				}
+
+				add(release, platform.String(), download.URL, checksum)
+			}
+
+			if release.ArtifactTemplate == "" {
+				continue
+			}
+
+			// only render the template, explicit artifacts have
+			// already been collected above.
+			tmpl := release
+			tmpl.Downloads = nil
+			tmpl.Artifacts = nil
+
+			for _, platform := range platforms {
+				artifact, err := installer.FindArtifactForPlatform(tmpl, platform)
+				if err != nil {
+					return nil, fmt.Errorf("plugin %s@%s: failed to render artifact template for %s: %w", release.Name, release.Version, platform, err)
+				}
+
+				add(release, platform.String(), artifact.URL, artifact.Checksum)
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+// isWebPage reports whether contentType indicates an HTML page which is
+// usually returned instead of the artifact for wrong release URLs.
+func isWebPage(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+func init() {
+	verifyIndexCommand.Flags().BoolVar(&checkIndexArtifacts, "check-artifacts", false, "Check that the artifacts of all plugin releases exist")
+	verifyIndexCommand.Flags().StringSliceVar(&checkPlatforms, "platform", supportedPlatforms, "The os/arch[/variant] platforms to render artifact templates for. May be specified multiple times")
+	verifyIndexCommand.Flags().IntVar(&checkConcurrency, "concurrency", 4, "The maximum number of concurrent artifact checks")
+	verifyIndexCommand.Flags().Float64Var(&checkRateLimit, "rate-limit", 10, "The maximum number of artifact checks started per second. Zero disables rate limiting")
+	verifyIndexCommand.Flags().Int64Var(&maxArtifactSize, "max-artifact-size", 256<<20, "The maximum artifact size in bytes. Zero disables the size check")
 }
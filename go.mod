@@ -3,6 +3,7 @@ module github.com/ppacher/portmaster-plugin-registry
 go 1.18
 
 require (
+	github.com/fatih/color v1.13.0
 	github.com/ghodss/yaml v1.0.0
 	github.com/google/renameio v1.0.1
 	github.com/hashicorp/go-getter/v2 v2.1.0
@@ -13,6 +14,7 @@ require (
 	github.com/hashicorp/hcl/v2 v2.14.0
 	github.com/safing/portmaster v0.9.5
 	github.com/spf13/cobra v1.5.0
+	github.com/ulikunitz/xz v0.5.8
 	github.com/valyala/fasttemplate v1.2.1
 )
 
@@ -20,7 +22,6 @@ require (
 	github.com/agext/levenshtein v1.2.3 // indirect
 	github.com/apparentlymart/go-textseg/v13 v13.0.0 // indirect
 	github.com/bgentry/go-netrc v0.0.0-20140422174119-9fd32a8b3d3d // indirect
-	github.com/fatih/color v1.13.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/go-cmp v0.5.8 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
@@ -36,7 +37,6 @@ require (
 	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
 	github.com/oklog/run v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	github.com/ulikunitz/xz v0.5.8 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/zclconf/go-cty v1.8.0 // indirect
 	golang.org/x/net v0.0.0-20220826154423-83b083e8dc8b // indirect
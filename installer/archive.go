@@ -0,0 +1,427 @@
+package installer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/ulikunitz/xz"
+)
+
+// Supported archive formats of plugin artifacts.
+const (
+	FormatTarGz  = "tar.gz"
+	FormatTarXz  = "tar.xz"
+	FormatTarBz2 = "tar.bz2"
+	FormatZip    = "zip"
+	FormatGzip   = "gz"
+	FormatXz     = "xz"
+	FormatBzip2  = "bz2"
+	FormatBinary = "binary"
+)
+
+// Limits applied when unpacking artifacts to protect against decompression
+// bombs.
+var (
+	// MaxUnpackedSize is the maximum number of bytes unpacked from an
+	// artifact.
+	MaxUnpackedSize int64 = 1 << 30
+
+	// MaxArchiveEntries is the maximum number of entries in an archive.
+	MaxArchiveEntries = 10000
+)
+
+var (
+	// ErrUnsupportedFormat is returned if an artifact uses an unsupported
+	// archive format.
+	ErrUnsupportedFormat = errors.New("unsupported archive format")
+
+	// ErrUnsafeArchivePath is returned if an archive contains an entry that
+	// would be unpacked outside of the target directory.
+	ErrUnsafeArchivePath = errors.New("unsafe path in archive")
+
+	// ErrArchiveTooLarge is returned if an archive exceeds MaxUnpackedSize
+	// or MaxArchiveEntries.
+	ErrArchiveTooLarge = errors.New("archive exceeds unpack limits")
+)
+
+// ValidateArchiveFormat returns an error if format is not empty and not one
+// of the supported archive formats.
+func ValidateArchiveFormat(format string) error {
+	switch format {
+	case "", FormatTarGz, FormatTarXz, FormatTarBz2, FormatZip, FormatGzip, FormatXz, FormatBzip2, FormatBinary:
+		return nil
+	default:
+		return fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+	}
+}
+
+// ValidateArchiveFile returns an error if archiveFile is not a valid relative
+// path or glob pattern.
+func ValidateArchiveFile(archiveFile string) error {
+	if _, err := cleanArchivePath(archiveFile); err != nil {
+		return err
+	}
+
+	if _, err := path.Match(archiveFile, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", archiveFile, err)
+	}
+
+	return nil
+}
+
+// unsupportedExtensions holds the extensions of well-known archive formats
+// that cannot be unpacked. They are rejected instead of being installed as
+// plain binaries.
+var unsupportedExtensions = []string{".zst", ".tzst", ".7z", ".rar"}
+
+// DetectArchiveFormat returns the archive format of an artifact based on the
+// extension of rawURL. Artifacts without a known extension are expected to
+// be plain binaries. ErrUnsupportedFormat is returned for archives that
+// cannot be unpacked.
+func DetectArchiveFormat(rawURL string) (string, error) {
+	name := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		name = u.Path
+	}
+	name = strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGz, nil
+	case strings.HasSuffix(name, ".tar.xz"), strings.HasSuffix(name, ".txz"):
+		return FormatTarXz, nil
+	case strings.HasSuffix(name, ".tar.bz2"), strings.HasSuffix(name, ".tbz2"), strings.HasSuffix(name, ".tbz"):
+		return FormatTarBz2, nil
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip, nil
+	case strings.HasSuffix(name, ".gz"):
+		return FormatGzip, nil
+	case strings.HasSuffix(name, ".xz"):
+		return FormatXz, nil
+	case strings.HasSuffix(name, ".bz2"):
+		return FormatBzip2, nil
+	}
+
+	for _, ext := range unsupportedExtensions {
+		if strings.HasSuffix(name, ext) {
+			return "", fmt.Errorf("%w %q", ErrUnsupportedFormat, strings.TrimPrefix(ext, "."))
+		}
+	}
+
+	return FormatBinary, nil
+}
+
+// unpackArtifact unpacks the artifact src of the given format into the
+// directory dst.
+func unpackArtifact(src, dst, format string) error {
+	limits := new(unpackLimits)
+
+	switch format {
+	case FormatZip:
+		return unpackZip(src, dst, limits)
+
+	case FormatTarGz, FormatTarXz, FormatTarBz2, FormatGzip, FormatXz, FormatBzip2:
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		var r io.Reader
+		switch format {
+		case FormatTarXz, FormatXz:
+			r, err = xz.NewReader(f)
+		case FormatTarBz2, FormatBzip2:
+			r = bzip2.NewReader(f)
+		default:
+			r, err = gzip.NewReader(f)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decompress artifact: %w", err)
+		}
+
+		switch format {
+		case FormatGzip, FormatXz, FormatBzip2:
+			name := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+
+			return unpackFile(filepath.Join(dst, name), r, 0755, limits)
+		}
+
+		return unpackTar(r, dst, limits)
+
+	default:
+		return fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+	}
+}
+
+func unpackTar(r io.Reader, dst string, limits *unpackLimits) error {
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		if err := limits.addEntry(); err != nil {
+			return err
+		}
+
+		target, err := archiveTarget(dst, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := unpackFile(target, tr, hdr.FileInfo().Mode(), limits); err != nil {
+				return err
+			}
+		default:
+			// links and special files are never required for plugins and
+			// might point outside of dst so skip them.
+			hclog.L().Debug("skipping unsupported archive entry", "name", hdr.Name, "type", hdr.Typeflag)
+		}
+	}
+}
+
+func unpackZip(src, dst string, limits *unpackLimits) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %w", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if err := limits.addEntry(); err != nil {
+			return err
+		}
+
+		target, err := archiveTarget(dst, f.Name)
+		if err != nil {
+			return err
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("failed to read %s from zip archive: %w", f.Name, err)
+			}
+
+			err = unpackFile(target, rc, mode, limits)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			hclog.L().Debug("skipping unsupported archive entry", "name", f.Name, "mode", mode)
+		}
+	}
+
+	return nil
+}
+
+func unpackFile(target string, r io.Reader, mode fs.FileMode, limits *unpackLimits) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+
+	if err := limits.copy(f, r); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// unpackLimits tracks the number of entries and bytes unpacked from an
+// archive.
+type unpackLimits struct {
+	entries int
+	size    int64
+}
+
+func (limits *unpackLimits) addEntry() error {
+	limits.entries++
+	if limits.entries > MaxArchiveEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, MaxArchiveEntries)
+	}
+
+	return nil
+}
+
+func (limits *unpackLimits) copy(dst io.Writer, src io.Reader) error {
+	n, err := io.CopyN(dst, src, MaxUnpackedSize-limits.size+1)
+	limits.size += n
+
+	if limits.size > MaxUnpackedSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrArchiveTooLarge, MaxUnpackedSize)
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+// archiveTarget returns the path of the archive entry name inside of dst.
+func archiveTarget(dst, name string) (string, error) {
+	cleaned, err := cleanArchivePath(name)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dst, filepath.FromSlash(cleaned)), nil
+}
+
+// cleanArchivePath cleans the slash separated path p and makes sure it does
+// not point outside of the archive root. Windows drive letters are rejected
+// on all systems as archives may be created on and for any platform.
+func cleanArchivePath(p string) (string, error) {
+	p = strings.ReplaceAll(p, "\\", "/")
+	cleaned := path.Clean(p)
+
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") || hasDriveLetter(p) || filepath.VolumeName(p) != "" {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeArchivePath, p)
+	}
+
+	return cleaned, nil
+}
+
+// hasDriveLetter reports whether p starts with a Windows drive letter like C:.
+func hasDriveLetter(p string) bool {
+	if len(p) < 2 || p[1] != ':' {
+		return false
+	}
+
+	c := p[0]
+
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// pluginFileFromArtifact returns the path of the plugin binary in dir which
+// holds the unpacked artifact. See structs.PluginDesc.ArchiveFile for how the
+// plugin binary is found.
+func pluginFileFromArtifact(plgName string, dir string, archiveFile string) (string, error) {
+	if archiveFile != "" {
+		pattern, err := cleanArchivePath(archiveFile)
+		if err != nil {
+			return "", err
+		}
+
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			return "", fmt.Errorf("invalid archive file pattern %q: %w", archiveFile, err)
+		}
+
+		files := regularFiles(matches)
+
+		// plain file names are searched in sub-directories as well
+		// as archives often contain a top-level directory.
+		if len(files) == 0 && !strings.ContainsAny(pattern, "/*?[") {
+			files, err = findFiles(dir, func(name string) bool {
+				return name == pattern
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+
+		return singleFile(files, archiveFile)
+	}
+
+	all, err := findFiles(dir, func(string) bool { return true })
+	if err != nil {
+		return "", err
+	}
+
+	if len(all) == 1 {
+		// there's only one file in the archive, this must
+		// be the plugin
+		return all[0], nil
+	}
+
+	files, err := findFiles(dir, func(name string) bool {
+		return name == plgName || name == plgName+".exe"
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return singleFile(files, plgName)
+}
+
+// findFiles returns all regular files in dir and its sub-directories whose
+// name matches.
+func findFiles(dir string, match func(name string) bool) ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type().IsRegular() && match(d.Name()) {
+			files = append(files, p)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+func regularFiles(paths []string) []string {
+	var files []string
+
+	for _, p := range paths {
+		if stat, err := os.Lstat(p); err == nil && stat.Mode().IsRegular() {
+			files = append(files, p)
+		}
+	}
+
+	return files
+}
+
+func singleFile(files []string, name string) (string, error) {
+	switch len(files) {
+	case 0:
+		return "", fmt.Errorf("failed to find plugin %q in archive", name)
+	case 1:
+		return files[0], nil
+	default:
+		return "", fmt.Errorf("failed to find plugin in archive: %q matches %d files", name, len(files))
+	}
+}
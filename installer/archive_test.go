@@ -0,0 +1,534 @@
+package installer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+// archiveEntry is an entry of a test archive. If link is set, a symbolic
+// link pointing to link is created instead of a regular file.
+type archiveEntry struct {
+	name string
+	body string
+	link string
+}
+
+// writeArchive creates an archive of the given format in dir and returns its
+// path. For FormatGzip and FormatXz only the body of the first entry is used.
+func writeArchive(t *testing.T, dir string, format string, entries []archiveEntry) string {
+	t.Helper()
+
+	name := "artifact." + format
+	if format == FormatGzip || format == FormatXz {
+		name = "plugin." + format
+	}
+	file := filepath.Join(dir, name)
+
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	switch format {
+	case FormatZip:
+		zw := zip.NewWriter(f)
+
+		for _, entry := range entries {
+			hdr := &zip.FileHeader{
+				Name:   entry.name,
+				Method: zip.Deflate,
+			}
+
+			body := entry.body
+			if entry.link != "" {
+				hdr.SetMode(fs.ModeSymlink | 0777)
+				body = entry.link
+			} else {
+				hdr.SetMode(0755)
+			}
+
+			w, err := zw.CreateHeader(hdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := io.WriteString(w, body); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+	case FormatGzip, FormatXz:
+		var w io.WriteCloser
+		if format == FormatXz {
+			w, err = xz.NewWriter(f)
+		} else {
+			w = gzip.NewWriter(f)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.WriteString(w, entries[0].body); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+	case FormatTarGz, FormatTarXz:
+		var w io.WriteCloser
+		if format == FormatTarXz {
+			w, err = xz.NewWriter(f)
+		} else {
+			w = gzip.NewWriter(f)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tw := tar.NewWriter(w)
+
+		for _, entry := range entries {
+			hdr := &tar.Header{
+				Name:     entry.name,
+				Mode:     0755,
+				Size:     int64(len(entry.body)),
+				Typeflag: tar.TypeReg,
+			}
+
+			if entry.link != "" {
+				hdr.Typeflag = tar.TypeSymlink
+				hdr.Linkname = entry.link
+				hdr.Size = 0
+			}
+
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+
+			if entry.link == "" {
+				if _, err := io.WriteString(tw, entry.body); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+	default:
+		t.Fatalf("unsupported test archive format %q", format)
+	}
+
+	return file
+}
+
+// unpackTestArchive creates an archive with entries and unpacks it. It
+// returns the directory the archive has been unpacked to.
+func unpackTestArchive(t *testing.T, format string, entries []archiveEntry) (string, error) {
+	t.Helper()
+
+	src := writeArchive(t, t.TempDir(), format, entries)
+	dst := t.TempDir()
+
+	return dst, unpackArtifact(src, dst, format)
+}
+
+var archiveFormats = []string{FormatTarGz, FormatTarXz, FormatZip}
+
+func TestCleanArchivePath(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected string
+		unsafe   bool
+	}{
+		{path: "plugin", expected: "plugin"},
+		{path: "./dist/plugin", expected: "dist/plugin"},
+		{path: "dist/../plugin", expected: "plugin"},
+		{path: "dist\\plugin.exe", expected: "dist/plugin.exe"},
+		{path: "..", unsafe: true},
+		{path: "../plugin", unsafe: true},
+		{path: "dist/../../plugin", unsafe: true},
+		{path: "..\\plugin", unsafe: true},
+		{path: "/plugin", unsafe: true},
+		{path: "/etc/passwd", unsafe: true},
+		{path: "\\plugin", unsafe: true},
+		{path: "C:\\plugin.exe", unsafe: true},
+		{path: "c:/plugin.exe", unsafe: true},
+		{path: "C:plugin.exe", unsafe: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			cleaned, err := cleanArchivePath(c.path)
+
+			if c.unsafe {
+				if !errors.Is(err, ErrUnsafeArchivePath) {
+					t.Errorf("expected ErrUnsafeArchivePath but got %q (err=%v)", cleaned, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if cleaned != c.expected {
+				t.Errorf("expected %q but got %q", c.expected, cleaned)
+			}
+		})
+	}
+}
+
+func TestUnpackArtifactUnsafePaths(t *testing.T) {
+	for _, format := range archiveFormats {
+		for _, name := range []string{"../plugin", "dist/../../plugin", "/plugin", "C:\\plugin.exe"} {
+			t.Run(format+" "+name, func(t *testing.T) {
+				_, err := unpackTestArchive(t, format, []archiveEntry{
+					{name: "README.md", body: "readme"},
+					{name: name, body: "evil"},
+				})
+
+				if !errors.Is(err, ErrUnsafeArchivePath) {
+					t.Errorf("expected ErrUnsafeArchivePath but got %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestUnpackArtifactLimits(t *testing.T) {
+	cases := []struct {
+		name       string
+		maxSize    int64
+		maxEntries int
+		entries    []archiveEntry
+		tooLarge   bool
+	}{
+		{
+			name:       "within limits",
+			maxSize:    10,
+			maxEntries: 2,
+			entries:    []archiveEntry{{name: "a", body: "12345"}, {name: "b", body: "12345"}},
+		},
+		{
+			name:       "size exceeded by single file",
+			maxSize:    10,
+			maxEntries: 10,
+			entries:    []archiveEntry{{name: "a", body: "12345678901"}},
+			tooLarge:   true,
+		},
+		{
+			name:       "size exceeded by all files",
+			maxSize:    10,
+			maxEntries: 10,
+			entries:    []archiveEntry{{name: "a", body: "123456"}, {name: "b", body: "123456"}},
+			tooLarge:   true,
+		},
+		{
+			name:       "too many entries",
+			maxSize:    100,
+			maxEntries: 2,
+			entries:    []archiveEntry{{name: "a"}, {name: "b"}, {name: "c"}},
+			tooLarge:   true,
+		},
+	}
+
+	for _, format := range append(archiveFormats, FormatGzip, FormatXz) {
+		for _, c := range cases {
+			if (format == FormatGzip || format == FormatXz) && len(c.entries) > 1 {
+				continue
+			}
+
+			t.Run(format+" "+c.name, func(t *testing.T) {
+				defer func(size int64, entries int) {
+					MaxUnpackedSize = size
+					MaxArchiveEntries = entries
+				}(MaxUnpackedSize, MaxArchiveEntries)
+
+				MaxUnpackedSize = c.maxSize
+				MaxArchiveEntries = c.maxEntries
+
+				_, err := unpackTestArchive(t, format, c.entries)
+
+				switch {
+				case c.tooLarge && !errors.Is(err, ErrArchiveTooLarge):
+					t.Errorf("expected ErrArchiveTooLarge but got %v", err)
+				case !c.tooLarge && err != nil:
+					t.Errorf("unexpected error: %s", err)
+				}
+			})
+		}
+	}
+}
+
+func TestUnpackArtifactSkipsSymlinks(t *testing.T) {
+	for _, format := range archiveFormats {
+		t.Run(format, func(t *testing.T) {
+			dst, err := unpackTestArchive(t, format, []archiveEntry{
+				{name: "plugin", body: "binary"},
+				{name: "passwd", link: "/etc/passwd"},
+				{name: "parent", link: "../"},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			for _, name := range []string{"passwd", "parent"} {
+				if _, err := os.Lstat(filepath.Join(dst, name)); !os.IsNotExist(err) {
+					t.Errorf("expected symlink %s to be skipped (err=%v)", name, err)
+				}
+			}
+
+			file, err := pluginFileFromArtifact("plugin", dst, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if filepath.Base(file) != "plugin" {
+				t.Errorf("expected plugin but got %s", file)
+			}
+		})
+	}
+}
+
+func TestPluginFileFromArtifact(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "dist/plugin-linux", body: "linux"},
+		{name: "dist/plugin-windows.exe", body: "windows"},
+		{name: "README.md", body: "readme"},
+	}
+
+	cases := []struct {
+		archiveFile string
+		expected    string
+	}{
+		// none
+		{archiveFile: "dist/plugin-darwin"},
+		{archiveFile: "dist/other-*"},
+		{archiveFile: "*.exe"},
+		{archiveFile: ""},
+
+		// one
+		{archiveFile: "dist/plugin-linux", expected: "dist/plugin-linux"},
+		{archiveFile: "plugin-windows.exe", expected: "dist/plugin-windows.exe"},
+		{archiveFile: "dist/*.exe", expected: "dist/plugin-windows.exe"},
+		{archiveFile: "*.md", expected: "README.md"},
+
+		// many
+		{archiveFile: "dist/plugin-*"},
+		{archiveFile: "dist/*"},
+	}
+
+	for _, format := range archiveFormats {
+		for _, c := range cases {
+			t.Run(format+" "+c.archiveFile, func(t *testing.T) {
+				dst, err := unpackTestArchive(t, format, entries)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+
+				file, err := pluginFileFromArtifact("plugin", dst, c.archiveFile)
+
+				if c.expected == "" {
+					if err == nil {
+						t.Errorf("expected an error but got %s", file)
+					}
+
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+
+				if file != filepath.Join(dst, filepath.FromSlash(c.expected)) {
+					t.Errorf("expected %s but got %s", c.expected, file)
+				}
+			})
+		}
+	}
+}
+
+// TestPluginFileFromArtifactGzip tests archive file patterns for raw gzip
+// and xz artifacts. These always contain a single file so patterns can never
+// match more than one file.
+func TestPluginFileFromArtifactGzip(t *testing.T) {
+	cases := []struct {
+		archiveFile string
+		expected    bool
+	}{
+		{archiveFile: "", expected: true},
+		{archiveFile: "plugin", expected: true},
+		{archiveFile: "plug*", expected: true},
+		{archiveFile: "other"},
+		{archiveFile: "dist/*"},
+	}
+
+	for _, format := range []string{FormatGzip, FormatXz} {
+		for _, c := range cases {
+			t.Run(format+" "+c.archiveFile, func(t *testing.T) {
+				dst, err := unpackTestArchive(t, format, []archiveEntry{{body: "binary"}})
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+
+				file, err := pluginFileFromArtifact("plugin", dst, c.archiveFile)
+
+				if !c.expected {
+					if err == nil {
+						t.Errorf("expected an error but got %s", file)
+					}
+
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+
+				content, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if filepath.Base(file) != "plugin" || string(content) != "binary" {
+					t.Errorf("unexpected plugin file %s with content %q", file, content)
+				}
+			})
+		}
+	}
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	cases := []struct {
+		url         string
+		expected    string
+		unsupported bool
+	}{
+		{url: "https://example.com/plugin.tar.gz", expected: FormatTarGz},
+		{url: "https://example.com/plugin.TGZ", expected: FormatTarGz},
+		{url: "https://example.com/plugin.tar.xz", expected: FormatTarXz},
+		{url: "https://example.com/plugin.txz", expected: FormatTarXz},
+		{url: "https://example.com/plugin.tar.bz2", expected: FormatTarBz2},
+		{url: "https://example.com/plugin.tbz2", expected: FormatTarBz2},
+		{url: "https://example.com/plugin.tbz", expected: FormatTarBz2},
+		{url: "https://example.com/plugin.zip?token=secret", expected: FormatZip},
+		{url: "https://example.com/plugin.gz", expected: FormatGzip},
+		{url: "https://example.com/plugin.xz", expected: FormatXz},
+		{url: "https://example.com/plugin.bz2", expected: FormatBzip2},
+		{url: "https://example.com/plugin", expected: FormatBinary},
+		{url: "https://example.com/plugin.exe", expected: FormatBinary},
+		{url: "https://example.com/plugin.tar.zst", unsupported: true},
+		{url: "https://example.com/plugin.zst", unsupported: true},
+		{url: "https://example.com/plugin.tzst", unsupported: true},
+		{url: "https://example.com/plugin.7z", unsupported: true},
+		{url: "https://example.com/plugin.rar", unsupported: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.url, func(t *testing.T) {
+			format, err := DetectArchiveFormat(c.url)
+
+			if c.unsupported {
+				if !errors.Is(err, ErrUnsupportedFormat) {
+					t.Errorf("expected ErrUnsupportedFormat but got %q (err=%v)", format, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if format != c.expected {
+				t.Errorf("expected %q but got %q", c.expected, format)
+			}
+		})
+	}
+}
+
+// TestUnpackArtifactBzip2 uses pre-built artifacts as there is no bzip2
+// encoder in the standard library.
+func TestUnpackArtifactBzip2(t *testing.T) {
+	cases := []struct {
+		format   string
+		name     string
+		artifact string
+		expected string
+	}{
+		{
+			format:   FormatBzip2,
+			name:     "plugin.bz2",
+			artifact: "425a68393141592653591b7b2427000000818030211020200021800c02696ee2ee48a70a12036f6484e0",
+			expected: "plugin",
+		},
+		{
+			// contains dist/plugin
+			format:   FormatTarBz2,
+			name:     "artifact.tar.bz2",
+			artifact: "425a6839314159265359816faf7100006efb80c98000044000eb80020074a55e20080820005432a68001ea680f53d4fd50492990068069881f757952107af4210ea6769a615d8810c0c79076ac87b08de446d48a309b566f6534fe3fd01e04ad412862481f8bb9229c284840b7d7b880",
+			expected: "dist/plugin",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			artifact, err := hex.DecodeString(c.artifact)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			src := filepath.Join(t.TempDir(), c.name)
+			if err := os.WriteFile(src, artifact, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			dst := t.TempDir()
+			if err := unpackArtifact(src, dst, c.format); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			file, err := pluginFileFromArtifact("plugin", dst, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if file != filepath.Join(dst, filepath.FromSlash(c.expected)) {
+				t.Errorf("expected %s but got %s", c.expected, file)
+			}
+
+			content, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(content) != "binary" {
+				t.Errorf("unexpected content %q", content)
+			}
+		})
+	}
+}
@@ -5,21 +5,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
-	"strings"
 
 	"github.com/google/renameio"
 	"github.com/hashicorp/go-getter/v2"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-version"
 	"github.com/ppacher/portmaster-plugin-registry/structs"
-	"github.com/valyala/fasttemplate"
 )
 
 var (
 	ErrNoMatchingArtifact = errors.New("no artifact matches the current system")
-	ErrUnsupportedArch    = errors.New("your system architecture is not yet supported")
 )
 
 // PluginInstaller can download and install plugins at a specified
@@ -30,7 +30,13 @@ type (
 	Installer interface {
 		// InstallPlugin should install the plugin defined in desc at the
 		// local system and return the path to the installed binary.
-		InstallPlugin(ctx context.Context, desc structs.PluginDesc) (string, error)
+		// If progress is set, it should be called while the plugin
+		// artifact is downloaded.
+		InstallPlugin(ctx context.Context, desc structs.PluginDesc, progress ProgressFunc) (string, error)
+
+		// UninstallPlugin should remove the plugin binary of plg and any
+		// temporary artifacts left over from the installation.
+		UninstallPlugin(ctx context.Context, plg structs.InstalledPlugin) error
 	}
 
 	// PluginInstaller implements the Installer interface and is capable of
@@ -39,12 +45,46 @@ type (
 		// TargetDirectory is the directory where plugins should be installed.
 		TargetDirectory string
 	}
+
+	// MatchingArtifact describes the artifact of a plugin that matches
+	// the current system.
+	MatchingArtifact struct {
+		// URL is the download URL of the artifact.
+		URL string
+
+		// ArchiveFile holds the name of the plugin binary inside the artifact
+		// archive, if any.
+		ArchiveFile string
+
+		// Format holds the archive format of the artifact. If empty, the
+		// format is detected from URL, see DetectArchiveFormat.
+		Format string
+
+		// Checksum holds the checksum of the artifact in the format
+		// expected by go-getter. That is, either <type>:<hex-digest> or
+		// file:<url> if the digest should be read from a checksums file.
+		// Checksum is empty if the plugin does not specify any digest.
+		Checksum string
+
+		// Variant, Libc and MinOSVersion hold the platform requirements
+		// of the artifact, if any.
+		Variant      string
+		Libc         string
+		MinOSVersion string
+	}
 )
 
 // InstallPlugin installs the plugin in the target directory and returns
-// the path of the installed plugin binary.
-func (installer *PluginInstaller) InstallPlugin(ctx context.Context, plg structs.PluginDesc) (string, error) {
-	pluginFile, err := DownloadPlugin(ctx, "", plg)
+// the path of the installed plugin binary. The download progress is reported
+// to progress, if set.
+func (installer *PluginInstaller) InstallPlugin(ctx context.Context, plg structs.PluginDesc, progress ProgressFunc) (string, error) {
+	tempDir, err := artifactTempDir(plg.Name)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+
+	pluginFile, err := DownloadPlugin(ctx, tempDir, plg, progress)
 	if err != nil {
 		return "", err
 	}
@@ -67,37 +107,146 @@ func (installer *PluginInstaller) InstallPlugin(ctx context.Context, plg structs
 	return targetFile, nil
 }
 
-func DownloadPlugin(ctx context.Context, dst string, plg structs.PluginDesc) (string, error) {
-	downloadURL, archiveFile, err := FindMatchingArtifact(plg)
+// UninstallPlugin removes the plugin binary of plg as well as any temporary
+// artifacts that might be left over from previous installations.
+func (installer *PluginInstaller) UninstallPlugin(ctx context.Context, plg structs.InstalledPlugin) error {
+	if err := os.Remove(plg.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove plugin binary: %w", err)
+	}
+	hclog.L().Info("plugin binary removed", "plugin", plg.Name, "path", plg.Path)
+
+	// all downloads of the plugin are placed in a dedicated directory so
+	// we never touch the artifacts of other plugins.
+	dir := artifactBaseDir(plg.Name)
+	if err := os.RemoveAll(dir); err != nil {
+		hclog.L().Error("failed to remove temporary artifacts", "plugin", plg.Name, "path", dir, "error", err)
+	}
+
+	return nil
+}
+
+// DownloadPlugin downloads the artifact for plg into dst and returns the path
+// of the plugin binary. If dst is empty a new temporary directory is created.
+//
+// If the plugin specifies a checksum for the matching artifact the download is
+// verified and rejected in case of a mismatch. The download progress is
+// reported to progress, if set.
+func DownloadPlugin(ctx context.Context, dst string, plg structs.PluginDesc, progress ProgressFunc) (string, error) {
+	return DownloadPluginForPlatform(ctx, dst, plg, CurrentPlatform(), progress)
+}
+
+// DownloadPluginForPlatform is like DownloadPlugin but downloads the artifact
+// for platform instead of the current system.
+func DownloadPluginForPlatform(ctx context.Context, dst string, plg structs.PluginDesc, platform Platform, progress ProgressFunc) (string, error) {
+	artifact, err := FindArtifactForPlatform(plg, platform)
 	if err != nil {
 		return "", err
 	}
 
+	format := artifact.Format
+	if format == "" {
+		format, err = DetectArchiveFormat(artifact.URL)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	if dst == "" {
 		var err error
-		dst, err = os.MkdirTemp("", plg.Name+"-*")
+		dst, err = artifactTempDir(plg.Name)
 		if err != nil {
 			return "", err
 		}
 	}
 
-	hclog.L().Info("downloading artifact", "plugin", plg.Name, "url", downloadURL, "dst", dst)
+	if artifact.Checksum == "" {
+		hclog.L().Warn("artifact checksum not specified, skipping verification", "plugin", plg.Name)
+	}
 
-	cli := new(getter.Client)
-	res, err := cli.Get(ctx, &getter.Request{
-		Src: downloadURL,
-		Dst: dst,
-	})
+	hclog.L().Info("downloading artifact", "plugin", plg.Name, "url", artifact.URL, "dst", dst)
+
+	u, err := url.Parse(artifact.URL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse artifact URL: %w", err)
+	}
+
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		name = plg.Name
+	}
+
+	artifactFile := filepath.Join(dst, name)
+	if err := downloadArtifact(ctx, artifact, artifactFile, progress); err != nil {
+		return "", err
+	}
+
+	if format == FormatBinary {
+		return artifactFile, nil
+	}
+
+	unpackDir, err := os.MkdirTemp(dst, "unpacked-")
 	if err != nil {
 		return "", err
 	}
 
-	pluginFile, err := pluginFileFromArtifact(plg.Name, res.Dst, archiveFile)
+	if err := unpackArtifact(artifactFile, unpackDir, format); err != nil {
+		return "", fmt.Errorf("failed to unpack artifact: %w", err)
+	}
+
+	if err := os.Remove(artifactFile); err != nil {
+		hclog.L().Warn("failed to remove artifact archive", "path", artifactFile, "error", err)
+	}
+
+	return pluginFileFromArtifact(plg.Name, unpackDir, artifact.ArchiveFile)
+}
+
+// DownloadArtifact downloads artifact to the file dst without unpacking it.
+// If artifact specifies a checksum the download is verified and rejected in
+// case of a mismatch.
+func DownloadArtifact(ctx context.Context, artifact MatchingArtifact, dst string) error {
+	return downloadArtifact(ctx, artifact, dst, nil)
+}
+
+func downloadArtifact(ctx context.Context, artifact MatchingArtifact, dst string, progress ProgressFunc) error {
+	downloadURL, err := artifact.sourceURL()
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(downloadURL)
 	if err != nil {
+		return fmt.Errorf("failed to parse artifact URL: %w", err)
+	}
+
+	// make sure go-getter does not unpack the artifact
+	q := u.Query()
+	q.Set("archive", "false")
+	u.RawQuery = q.Encode()
+
+	_, err = new(getter.Client).Get(ctx, &getter.Request{
+		Src:              u.String(),
+		Dst:              dst,
+		GetMode:          getter.ModeFile,
+		Copy:             true,
+		ProgressListener: newProgressTracker(progress),
+	})
+
+	return err
+}
+
+// artifactBaseDir returns the directory that holds all temporary download
+// directories of the plugin plgName.
+func artifactBaseDir(plgName string) string {
+	return filepath.Join(os.TempDir(), "pecs-"+plgName)
+}
+
+func artifactTempDir(plgName string) (string, error) {
+	baseDir := artifactBaseDir(plgName)
+	if err := os.MkdirAll(baseDir, 0700); err != nil {
 		return "", err
 	}
 
-	return pluginFile, err
+	return os.MkdirTemp(baseDir, "download-*")
 }
 
 func moveFile(destination, source string) error {
@@ -128,112 +277,263 @@ func moveFile(destination, source string) error {
 	return nil
 }
 
-func pluginFileFromArtifact(plgName string, artifact string, archiveFile string) (string, error) {
-	stat, err := os.Stat(artifact)
-	if err != nil {
-		return "", err
+// FindMatchingArtifact returns the artifact of plg that matches the current
+// system, see CurrentPlatform.
+func FindMatchingArtifact(plg structs.PluginDesc) (MatchingArtifact, error) {
+	return FindArtifactForPlatform(plg, CurrentPlatform())
+}
+
+// FindArtifactForPlatform returns the artifact of plg that matches best for
+// platform.
+//
+// Downloads and the deprecated Artifacts of plg are considered and must match
+// the operating system and architecture of platform. Artifacts built for the
+// same architecture variant are preferred over those built for a lower variant
+// and those without a variant. Artifacts linked against a different C library
+// or requiring a higher operating system version than platform are never used.
+//
+// If no artifact matches, the artifact template of plg is used, if any.
+func FindArtifactForPlatform(plg structs.PluginDesc, platform Platform) (MatchingArtifact, error) {
+	platform.Arch = normalizeArch(platform.Arch)
+
+	var (
+		best      *structs.Download
+		bestScore artifactScore
+	)
+
+	for _, download := range PluginDownloads(plg) {
+		download := download
+
+		score, ok := matchDownload(download, platform)
+		if !ok {
+			continue
+		}
+
+		if best == nil || score.betterThan(bestScore) {
+			best = &download
+			bestScore = score
+		}
 	}
 
-	if !stat.IsDir() {
-		return artifact, nil
+	if best == nil {
+		// if there's an artifact_template try to use that one
+		if plg.ArtifactTemplate == "" {
+			return MatchingArtifact{}, ErrNoMatchingArtifact
+		}
+
+		downloadURL, err := executeTemplate(plg.ArtifactTemplate, plg, platform)
+		if err != nil {
+			return MatchingArtifact{}, err
+		}
+
+		checksum, err := checksumsFile(plg, platform)
+		if err != nil {
+			return MatchingArtifact{}, err
+		}
+
+		return MatchingArtifact{
+			URL:         downloadURL,
+			ArchiveFile: plg.ArchiveFile,
+			Format:      plg.ArchiveFormat,
+			Checksum:    checksum,
+		}, nil
 	}
 
-	if archiveFile != "" {
-		return filepath.Join(artifact, archiveFile), nil
+	archiveFile := best.ArchiveFile
+	if archiveFile == "" {
+		archiveFile = plg.ArchiveFile
 	}
 
-	files, err := os.ReadDir(artifact)
-	if err != nil {
-		return "", err
+	format := best.Format
+	if format == "" {
+		format = plg.ArchiveFormat
 	}
 
-	if len(files) == 1 {
-		// there's only one file in the directory, this must
-		// be the plugin
-		if files[0].IsDir() {
-			return "", fmt.Errorf("failed to find plugin in archive")
+	var checksum string
+	switch {
+	case best.SHA512 != "":
+		checksum = "sha512:" + best.SHA512
+	case best.SHA256 != "":
+		checksum = "sha256:" + best.SHA256
+	default:
+		var err error
+		checksum, err = checksumsFile(plg, platform)
+		if err != nil {
+			return MatchingArtifact{}, err
 		}
+	}
+
+	return MatchingArtifact{
+		URL:          best.URL,
+		ArchiveFile:  archiveFile,
+		Format:       format,
+		Checksum:     checksum,
+		Variant:      best.Variant,
+		Libc:         best.Libc,
+		MinOSVersion: best.MinOSVersion,
+	}, nil
+}
 
-		return filepath.Join(artifact, files[0].Name()), nil
+// artifactScore ranks how well a download matches a platform. Members are
+// compared in order, higher is better.
+type artifactScore struct {
+	variant int
+	libc    int
+	minOS   int
+}
+
+func (score artifactScore) betterThan(other artifactScore) bool {
+	if score.variant != other.variant {
+		return score.variant > other.variant
+	}
+
+	if score.libc != other.libc {
+		return score.libc > other.libc
 	}
 
-	// try to search for the plugin file
-	for _, file := range files {
-		if file.Name() == plgName {
-			return filepath.Join(artifact, file.Name()), nil
+	return score.minOS > other.minOS
+}
+
+// matchDownload reports whether download can be used on platform and how
+// well it matches.
+func matchDownload(download structs.Download, platform Platform) (artifactScore, bool) {
+	var score artifactScore
+
+	if download.OS != platform.OS || normalizeArch(download.Arch) != platform.Arch {
+		return score, false
+	}
+
+	switch {
+	case download.Variant == platform.Variant:
+		score.variant = 3000
+	case download.Variant == "":
+		score.variant = 1000
+	default:
+		level, ok := variantLevel(download.Variant)
+		if !ok {
+			return score, false
 		}
 
-		if runtime.GOOS == "windows" {
-			if file.Name() == plgName+".exe" {
-				return filepath.Join(artifact, file.Name()), nil
+		if platform.Variant == "" {
+			// prefer the lowest variant if we don't know what the
+			// system supports.
+			score.variant = -level
+		} else {
+			targetLevel, ok := variantLevel(platform.Variant)
+			if !ok || level > targetLevel {
+				return score, false
 			}
+
+			score.variant = 2000 + level
 		}
 	}
 
-	return "", fmt.Errorf("failed to find plugin in archive")
-}
+	switch {
+	case download.Libc == "":
+		score.libc = 1
+	case download.Libc == platform.Libc:
+		score.libc = 2
+	case platform.Libc != "":
+		return score, false
+	}
 
-func FindMatchingArtifact(plg structs.PluginDesc) (string, string, error) {
-	// find the correct artifact
-	var artifact *structs.Artifact
+	if download.MinOSVersion != "" && platform.OSVersion != "" {
+		minVersion, err := version.NewVersion(download.MinOSVersion)
+		if err != nil {
+			return score, false
+		}
 
-	for _, a := range plg.Artifacts {
-		if a.OS == runtime.GOOS {
-			artifact = &a
+		osVersion, err := version.NewVersion(platform.OSVersion)
+		if err != nil {
+			return score, false
+		}
 
-			break
+		if osVersion.LessThan(minVersion) {
+			return score, false
 		}
+
+		score.minOS = 1
 	}
 
-	if artifact == nil {
+	return score, true
+}
 
-		// if there's an artifact_template try to use that one
-		if plg.ArtifactTemplate != "" {
-			buf := new(strings.Builder)
-			_, err := fasttemplate.Execute(plg.ArtifactTemplate, "{{", "}}", buf, map[string]any{
-				"os":               runtime.GOOS,
-				"arch":             runtime.GOARCH,
-				"version":          plg.Version,
-				"stripped_version": strings.TrimPrefix(plg.Version, "v"),
-				"plugin_name":      plg.Name,
-				"source":           plg.SourceURL,
-				"archive_file":     plg.ArchiveFile,
-			})
-			if err != nil {
-				return "", "", err
+// PluginDownloads returns the downloads of plg including those defined
+// using the deprecated Artifacts.
+func PluginDownloads(plg structs.PluginDesc) []structs.Download {
+	downloads := make([]structs.Download, 0, len(plg.Downloads)+4*len(plg.Artifacts))
+	downloads = append(downloads, plg.Downloads...)
+
+	for _, artifact := range plg.Artifacts {
+		for _, arch := range []struct {
+			goarch string
+			url    string
+			keys   []string
+		}{
+			{"amd64", artifact.AMD64, []string{"amd64"}},
+			{"arm", artifact.ARM, []string{"arm"}},
+			{"arm64", artifact.ARM64, []string{"arm64"}},
+			{"386", artifact.I386, []string{"i386", "386"}},
+		} {
+			if arch.url == "" {
+				continue
+			}
+
+			download := structs.Download{
+				OS:          artifact.OS,
+				Arch:        arch.goarch,
+				URL:         arch.url,
+				ArchiveFile: artifact.ArchiveFile,
 			}
 
-			return buf.String(), plg.ArchiveFile, nil
+			for _, key := range arch.keys {
+				if download.SHA256 == "" {
+					download.SHA256 = artifact.SHA256[key]
+				}
+
+				if download.SHA512 == "" {
+					download.SHA512 = artifact.SHA512[key]
+				}
+			}
+
+			downloads = append(downloads, download)
 		}
+	}
 
-		return "", "", ErrNoMatchingArtifact
+	return downloads
+}
+
+// sourceURL returns the go-getter source URL for the artifact. If a checksum
+// is specified it is added to the URL so go-getter verifies the download
+// before unpacking it.
+func (artifact MatchingArtifact) sourceURL() (string, error) {
+	if artifact.Checksum == "" {
+		return artifact.URL, nil
 	}
 
-	archiveFile := artifact.ArchiveFile
-	if archiveFile == "" {
-		archiveFile = plg.ArchiveFile
+	u, err := url.Parse(artifact.URL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse artifact URL: %w", err)
 	}
 
-	// get the correct architecture download link
-	url := ""
-	switch runtime.GOARCH {
-	case "amd64":
-		url = artifact.AMD64
-	case "arm":
-		url = artifact.ARM
-	case "arm64":
-		url = artifact.ARM64
-	case "i386":
-		url = artifact.I386
-	default:
-		return "", "", ErrUnsupportedArch
+	q := u.Query()
+	q.Set("checksum", artifact.Checksum)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func checksumsFile(plg structs.PluginDesc, platform Platform) (string, error) {
+	if plg.Checksums == "" {
+		return "", nil
 	}
 
-	if url == "" {
-		return "", "", ErrNoMatchingArtifact
+	checksumsURL, err := executeTemplate(plg.Checksums, plg, platform)
+	if err != nil {
+		return "", fmt.Errorf("failed to render checksums URL: %w", err)
 	}
 
-	return url, archiveFile, nil
+	return "file:" + checksumsURL, nil
 }
 
 // Interface checks
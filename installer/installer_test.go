@@ -0,0 +1,252 @@
+package installer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+func TestFindArtifactForPlatform(t *testing.T) {
+	linux := func(arch string, variant string) structs.Download {
+		return structs.Download{
+			OS:      "linux",
+			Arch:    arch,
+			Variant: variant,
+			URL:     "linux-" + arch + variant,
+		}
+	}
+
+	withLibc := func(download structs.Download, libc string) structs.Download {
+		download.Libc = libc
+		download.URL += "-" + libc
+
+		return download
+	}
+
+	withMinOS := func(download structs.Download, minVersion string) structs.Download {
+		download.MinOSVersion = minVersion
+		download.URL += "-" + minVersion
+
+		return download
+	}
+
+	cases := []struct {
+		name      string
+		downloads []structs.Download
+		platform  Platform
+		expected  string
+	}{
+		// variant ranking
+		{
+			name:      "arm exact variant",
+			downloads: []structs.Download{linux("arm", "v6"), linux("arm", "v7"), linux("arm", "")},
+			platform:  Platform{OS: "linux", Arch: "arm", Variant: "v7"},
+			expected:  "linux-armv7",
+		},
+		{
+			name:      "arm highest supported variant",
+			downloads: []structs.Download{linux("arm", ""), linux("arm", "v6")},
+			platform:  Platform{OS: "linux", Arch: "arm", Variant: "v7"},
+			expected:  "linux-armv6",
+		},
+		{
+			name:      "arm variant too high",
+			downloads: []structs.Download{linux("arm", "v7")},
+			platform:  Platform{OS: "linux", Arch: "arm", Variant: "v6"},
+		},
+		{
+			name:      "arm without variant prefers generic artifact",
+			downloads: []structs.Download{linux("arm", "v7"), linux("arm", "v6"), linux("arm", "")},
+			platform:  Platform{OS: "linux", Arch: "arm"},
+			expected:  "linux-arm",
+		},
+		{
+			name:      "arm unknown variant prefers lowest variant",
+			downloads: []structs.Download{linux("arm", "v7"), linux("arm", "v6")},
+			platform:  Platform{OS: "linux", Arch: "arm"},
+			expected:  "linux-armv6",
+		},
+		{
+			name:      "amd64 v3",
+			downloads: []structs.Download{linux("amd64", "v1"), linux("amd64", "v3")},
+			platform:  Platform{OS: "linux", Arch: "amd64", Variant: "v3"},
+			expected:  "linux-amd64v3",
+		},
+		{
+			name:      "amd64 v2 falls back to v1",
+			downloads: []structs.Download{linux("amd64", "v3"), linux("amd64", "v1")},
+			platform:  Platform{OS: "linux", Arch: "amd64", Variant: "v2"},
+			expected:  "linux-amd64v1",
+		},
+		{
+			name:      "amd64 v1 prefers variant over generic artifact",
+			downloads: []structs.Download{linux("amd64", ""), linux("amd64", "v1")},
+			platform:  Platform{OS: "linux", Arch: "amd64", Variant: "v1"},
+			expected:  "linux-amd64v1",
+		},
+		{
+			name:      "arch alias",
+			downloads: []structs.Download{linux("x86_64", "")},
+			platform:  Platform{OS: "linux", Arch: "amd64"},
+			expected:  "linux-x86_64",
+		},
+
+		// libc exclusion
+		{
+			name:      "matching libc",
+			downloads: []structs.Download{linux("amd64", ""), withLibc(linux("amd64", ""), "glibc"), withLibc(linux("amd64", ""), "musl")},
+			platform:  Platform{OS: "linux", Arch: "amd64", Libc: "musl"},
+			expected:  "linux-amd64-musl",
+		},
+		{
+			name:      "different libc is excluded",
+			downloads: []structs.Download{withLibc(linux("amd64", ""), "glibc")},
+			platform:  Platform{OS: "linux", Arch: "amd64", Libc: "musl"},
+		},
+		{
+			name:      "different libc falls back to generic artifact",
+			downloads: []structs.Download{withLibc(linux("amd64", ""), "glibc"), linux("amd64", "")},
+			platform:  Platform{OS: "linux", Arch: "amd64", Libc: "musl"},
+			expected:  "linux-amd64",
+		},
+		{
+			name:      "unknown libc",
+			downloads: []structs.Download{withLibc(linux("amd64", ""), "glibc")},
+			platform:  Platform{OS: "linux", Arch: "amd64"},
+			expected:  "linux-amd64-glibc",
+		},
+
+		// minimum OS version
+		{
+			name:      "os version too low",
+			downloads: []structs.Download{withMinOS(linux("amd64", ""), "5.10")},
+			platform:  Platform{OS: "linux", Arch: "amd64", OSVersion: "5.4.0"},
+		},
+		{
+			name:      "os version satisfied",
+			downloads: []structs.Download{linux("amd64", ""), withMinOS(linux("amd64", ""), "5.10")},
+			platform:  Platform{OS: "linux", Arch: "amd64", OSVersion: "6.1.0"},
+			expected:  "linux-amd64-5.10",
+		},
+		{
+			name:      "os version too low falls back",
+			downloads: []structs.Download{withMinOS(linux("amd64", ""), "5.10"), linux("amd64", "")},
+			platform:  Platform{OS: "linux", Arch: "amd64", OSVersion: "4.19"},
+			expected:  "linux-amd64",
+		},
+		{
+			name:      "unknown os version",
+			downloads: []structs.Download{withMinOS(linux("amd64", ""), "5.10")},
+			platform:  Platform{OS: "linux", Arch: "amd64"},
+			expected:  "linux-amd64-5.10",
+		},
+		{
+			name:      "variant ranks before libc and os version",
+			downloads: []structs.Download{withMinOS(withLibc(linux("arm", "v6"), "glibc"), "5.10"), linux("arm", "v7")},
+			platform:  Platform{OS: "linux", Arch: "arm", Variant: "v7", Libc: "glibc", OSVersion: "6.1"},
+			expected:  "linux-armv7",
+		},
+
+		// tie-breaking
+		{
+			name:      "first of equal artifacts wins",
+			downloads: []structs.Download{withLibc(linux("amd64", ""), "glibc"), withLibc(linux("amd64", ""), "glibc")},
+			platform:  Platform{OS: "linux", Arch: "amd64", Libc: "glibc"},
+			expected:  "linux-amd64-glibc",
+		},
+		{
+			name: "first of equal artifacts wins regardless of url",
+			downloads: []structs.Download{
+				{OS: "linux", Arch: "amd64", URL: "first"},
+				{OS: "linux", Arch: "amd64", URL: "second"},
+			},
+			platform: Platform{OS: "linux", Arch: "amd64"},
+			expected: "first",
+		},
+		{
+			name:      "libc breaks variant tie",
+			downloads: []structs.Download{linux("amd64", "v1"), withLibc(linux("amd64", "v1"), "glibc")},
+			platform:  Platform{OS: "linux", Arch: "amd64", Variant: "v1", Libc: "glibc"},
+			expected:  "linux-amd64v1-glibc",
+		},
+		{
+			name:      "min os version breaks libc tie",
+			downloads: []structs.Download{linux("amd64", ""), withMinOS(linux("amd64", ""), "10.0")},
+			platform:  Platform{OS: "linux", Arch: "amd64", OSVersion: "10.0.19045"},
+			expected:  "linux-amd64-10.0",
+		},
+
+		// os and arch
+		{
+			name:      "different os",
+			downloads: []structs.Download{linux("amd64", "")},
+			platform:  Platform{OS: "windows", Arch: "amd64"},
+		},
+		{
+			name:      "different arch",
+			downloads: []structs.Download{linux("arm64", "")},
+			platform:  Platform{OS: "linux", Arch: "amd64"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			plg := structs.PluginDesc{
+				Name:      "test",
+				Version:   "1.0.0",
+				Downloads: c.downloads,
+			}
+
+			artifact, err := FindArtifactForPlatform(plg, c.platform)
+
+			if c.expected == "" {
+				if !errors.Is(err, ErrNoMatchingArtifact) {
+					t.Fatalf("expected ErrNoMatchingArtifact but got %q (err=%v)", artifact.URL, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if artifact.URL != c.expected {
+				t.Errorf("expected %q but got %q", c.expected, artifact.URL)
+			}
+		})
+	}
+}
+
+func TestMatchDownloadInvalidVersions(t *testing.T) {
+	cases := []struct {
+		name     string
+		download structs.Download
+		platform Platform
+	}{
+		{
+			name:     "invalid variant",
+			download: structs.Download{OS: "linux", Arch: "arm", Variant: "armhf"},
+			platform: Platform{OS: "linux", Arch: "arm", Variant: "v7"},
+		},
+		{
+			name:     "invalid platform variant",
+			download: structs.Download{OS: "linux", Arch: "arm", Variant: "v6"},
+			platform: Platform{OS: "linux", Arch: "arm", Variant: "armhf"},
+		},
+		{
+			name:     "invalid min os version",
+			download: structs.Download{OS: "linux", Arch: "amd64", MinOSVersion: "latest"},
+			platform: Platform{OS: "linux", Arch: "amd64", OSVersion: "6.1"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, ok := matchDownload(c.download, c.platform); ok {
+				t.Errorf("expected download to not match")
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package installer
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Platform describes the target system a plugin should be installed on.
+type Platform struct {
+	// OS is the operating system following the values of runtime.GOOS.
+	OS string
+
+	// Arch is the architecture following the values of runtime.GOARCH.
+	Arch string
+
+	// Variant may hold the architecture variant like v7 for arm. If empty,
+	// artifacts for the lowest variant are preferred.
+	Variant string
+
+	// Libc may hold the C library available on the system. If empty,
+	// artifacts are not filtered by C library.
+	Libc string
+
+	// OSVersion may hold the version of the operating system. If empty,
+	// minimum operating system version requirements are ignored.
+	OSVersion string
+}
+
+// ParsePlatform parses a platform in the form of os/arch[/variant].
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+	}
+
+	platform := Platform{
+		OS:   parts[0],
+		Arch: normalizeArch(parts[1]),
+	}
+
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+
+	return platform, nil
+}
+
+// String returns the platform in the form of os/arch[/variant].
+func (platform Platform) String() string {
+	s := platform.OS + "/" + platform.Arch
+	if platform.Variant != "" {
+		s += "/" + platform.Variant
+	}
+
+	return s
+}
+
+// CurrentPlatform returns the platform of the local system.
+func CurrentPlatform() Platform {
+	return Platform{
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Variant:   buildVariant(),
+		Libc:      detectLibc(),
+		OSVersion: osVersion(),
+	}
+}
+
+// buildVariant returns the architecture variant the running binary has been
+// built for. As the binary is running, the system supports at least this
+// variant.
+func buildVariant() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	var key string
+	switch runtime.GOARCH {
+	case "arm":
+		key = "GOARM"
+	case "amd64":
+		key = "GOAMD64"
+	default:
+		return ""
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == key && setting.Value != "" {
+			return "v" + strings.TrimPrefix(setting.Value, "v")
+		}
+	}
+
+	return ""
+}
+
+// detectLibc returns the C library used on Linux systems.
+func detectLibc() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+
+	if matches, _ := filepath.Glob("/lib/ld-musl-*.so.1"); len(matches) > 0 {
+		return "musl"
+	}
+
+	return "glibc"
+}
+
+// normalizeArch converts common architecture aliases into the
+// respective runtime.GOARCH value.
+func normalizeArch(arch string) string {
+	switch arch {
+	case "i386", "x86":
+		return "386"
+	case "x86_64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	default:
+		return arch
+	}
+}
+
+// variantLevel parses variants in the form of v7 or 7. It returns false if
+// variant is not in a supported form.
+func variantLevel(variant string) (int, bool) {
+	level, err := strconv.Atoi(strings.TrimPrefix(variant, "v"))
+	if err != nil {
+		return 0, false
+	}
+
+	return level, true
+}
@@ -0,0 +1,33 @@
+package installer
+
+import (
+	"strings"
+	"syscall"
+)
+
+// osVersion returns the version of the Linux kernel, for example 5.15.0.
+func osVersion() string {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return ""
+	}
+
+	var release strings.Builder
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+
+		release.WriteByte(byte(c))
+	}
+
+	// strip distribution specific suffixes like -1034-azure
+	version := release.String()
+	if idx := strings.IndexFunc(version, func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	}); idx >= 0 {
+		version = version[:idx]
+	}
+
+	return strings.TrimRight(version, ".")
+}
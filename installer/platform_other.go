@@ -0,0 +1,8 @@
+//go:build !linux
+
+package installer
+
+// osVersion is not yet supported on this operating system.
+func osVersion() string {
+	return ""
+}
@@ -0,0 +1,67 @@
+package installer
+
+import (
+	"io"
+
+	"github.com/hashicorp/go-getter/v2"
+)
+
+type (
+	// ProgressFunc is called while a plugin artifact is downloaded. downloaded
+	// is the number of bytes received so far and total the size of the artifact
+	// or -1 if the size is unknown.
+	ProgressFunc func(downloaded, total int64)
+
+	// progressTracker implements getter.ProgressTracker and reports the
+	// download progress to a ProgressFunc.
+	progressTracker struct {
+		fn ProgressFunc
+	}
+
+	// progressReader wraps the download stream and reports each read to
+	// fn.
+	progressReader struct {
+		io.ReadCloser
+
+		fn         ProgressFunc
+		downloaded int64
+		total      int64
+	}
+)
+
+// newProgressTracker returns a getter.ProgressTracker that reports to fn or
+// nil if fn is nil.
+func newProgressTracker(fn ProgressFunc) getter.ProgressTracker {
+	if fn == nil {
+		return nil
+	}
+
+	return &progressTracker{fn: fn}
+}
+
+func (tracker *progressTracker) TrackProgress(src string, currentSize, totalSize int64, stream io.ReadCloser) io.ReadCloser {
+	// go-getter reports currentSize + Content-Length which is less than
+	// currentSize if the server did not send a Content-Length.
+	if totalSize <= 0 || totalSize < currentSize {
+		totalSize = -1
+	}
+
+	tracker.fn(currentSize, totalSize)
+
+	return &progressReader{
+		ReadCloser: stream,
+		fn:         tracker.fn,
+		downloaded: currentSize,
+		total:      totalSize,
+	}
+}
+
+func (reader *progressReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(p)
+	if n > 0 {
+		reader.downloaded += int64(n)
+		reader.fn(reader.downloaded, reader.total)
+	}
+
+	return n, err
+}
@@ -0,0 +1,143 @@
+package installer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+	"github.com/valyala/fasttemplate"
+)
+
+// DefaultExtension is the value of the {{ext}} placeholder if the plugin
+// does not define an extension for the target operating system.
+const DefaultExtension = "tar.gz"
+
+// templatePlaceholders holds all placeholders supported in artifact and
+// checksum templates.
+var templatePlaceholders = []string{
+	"os",
+	"arch",
+	"variant",
+	"ext",
+	"version",
+	"stripped_version",
+	"plugin_name",
+	"source",
+	"archive_file",
+}
+
+// templateFilters holds all filters that may be applied to placeholders
+// using {{placeholder|filter}}.
+var templateFilters = map[string]func(string) string{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"title": titleCase,
+	"trimv": func(s string) string {
+		return strings.TrimPrefix(s, "v")
+	},
+}
+
+// ValidateTemplate parses tmpl and returns an error if it is malformed or
+// uses unknown placeholders or filters.
+func ValidateTemplate(tmpl string) error {
+	vars := make(map[string]string, len(templatePlaceholders))
+	for _, name := range templatePlaceholders {
+		vars[name] = ""
+	}
+
+	_, err := renderTemplate(tmpl, vars)
+
+	return err
+}
+
+func executeTemplate(tmpl string, plg structs.PluginDesc, platform Platform) (string, error) {
+	return renderTemplate(tmpl, templateVars(plg, platform))
+}
+
+// templateVars returns the values of all placeholders for plg and platform
+// with the name mappings of plg applied.
+func templateVars(plg structs.PluginDesc, platform Platform) map[string]string {
+	osName := platform.OS
+	if name, ok := plg.OSNames[platform.OS]; ok {
+		osName = name
+	}
+
+	archName := platform.Arch
+	if name, ok := plg.ArchNames[platform.Arch+"/"+platform.Variant]; ok && platform.Variant != "" {
+		archName = name
+	} else if name, ok := plg.ArchNames[platform.Arch]; ok {
+		archName = name
+	}
+
+	ext, ok := plg.Extensions[platform.OS]
+	if !ok {
+		ext, ok = plg.Extensions["default"]
+	}
+	if !ok {
+		ext = DefaultExtension
+	}
+
+	return map[string]string{
+		"os":               osName,
+		"arch":             archName,
+		"variant":          platform.Variant,
+		"ext":              strings.TrimPrefix(ext, "."),
+		"version":          plg.Version,
+		"stripped_version": strings.TrimPrefix(plg.Version, "v"),
+		"plugin_name":      plg.Name,
+		"source":           plg.SourceURL,
+		"archive_file":     plg.ArchiveFile,
+	}
+}
+
+func renderTemplate(tmpl string, vars map[string]string) (string, error) {
+	t, err := fasttemplate.NewTemplate(tmpl, "{{", "}}")
+	if err != nil {
+		return "", fmt.Errorf("invalid template: missing closing }}")
+	}
+
+	return t.ExecuteFuncStringWithErr(func(w io.Writer, tag string) (int, error) {
+		value, err := evalPlaceholder(tag, vars)
+		if err != nil {
+			return 0, err
+		}
+
+		return io.WriteString(w, value)
+	})
+}
+
+// evalPlaceholder evaluates a placeholder in the form of name|filter|filter.
+func evalPlaceholder(tag string, vars map[string]string) (string, error) {
+	parts := strings.Split(tag, "|")
+
+	name := strings.TrimSpace(parts[0])
+	value, ok := vars[name]
+	if !ok {
+		return "", fmt.Errorf("unknown placeholder {{%s}}", name)
+	}
+
+	for _, filterName := range parts[1:] {
+		filterName = strings.TrimSpace(filterName)
+
+		filter, ok := templateFilters[filterName]
+		if !ok {
+			return "", fmt.Errorf("unknown filter %q in placeholder {{%s}}", filterName, strings.TrimSpace(tag))
+		}
+
+		value = filter(value)
+	}
+
+	return value, nil
+}
+
+func titleCase(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+
+	return string(unicode.ToUpper(r)) + s[size:]
+}
@@ -0,0 +1,95 @@
+package installer
+
+import (
+	"testing"
+
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	cases := []struct {
+		tmpl    string
+		invalid bool
+	}{
+		{tmpl: ""},
+		{tmpl: "https://example.com/plugin.tar.gz"},
+		{tmpl: "https://example.com/{{version}}/{{plugin_name}}_{{os}}_{{arch}}{{variant}}.{{ext}}"},
+		{tmpl: "{{ source }}/{{stripped_version}}/{{archive_file}}"},
+		{tmpl: "{{os|title}}_{{arch | upper | lower}}_{{version|trimv}}"},
+
+		// unknown placeholders
+		{tmpl: "{{platform}}", invalid: true},
+		{tmpl: "{{}}", invalid: true},
+		{tmpl: "{{|lower}}", invalid: true},
+		{tmpl: "{{OS}}", invalid: true},
+
+		// unknown filters
+		{tmpl: "{{os|capitalize}}", invalid: true},
+		{tmpl: "{{os|lower|}}", invalid: true},
+		{tmpl: "{{os|Lower}}", invalid: true},
+
+		// malformed
+		{tmpl: "{{os", invalid: true},
+		{tmpl: "https://example.com/{{version}/plugin", invalid: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.tmpl, func(t *testing.T) {
+			err := ValidateTemplate(c.tmpl)
+
+			if c.invalid && err == nil {
+				t.Errorf("expected an error")
+			}
+
+			if !c.invalid && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestTemplateFilters(t *testing.T) {
+	plg := structs.PluginDesc{
+		Name:      "My-Plugin",
+		Version:   "v1.2.3",
+		SourceURL: "vendor",
+		OSNames:   map[string]string{"darwin": "macOS"},
+	}
+
+	cases := []struct {
+		tmpl     string
+		platform Platform
+		expected string
+	}{
+		{tmpl: "{{plugin_name|lower}}", expected: "my-plugin"},
+		{tmpl: "{{plugin_name|upper}}", expected: "MY-PLUGIN"},
+		{tmpl: "{{os|upper}}", platform: Platform{OS: "darwin"}, expected: "MACOS"},
+		{tmpl: "{{os|title}}", expected: "Linux"},
+		{tmpl: "{{os|title}}", platform: Platform{OS: "darwin"}, expected: "MacOS"},
+		{tmpl: "{{variant|title}}", expected: ""},
+		{tmpl: "{{version|trimv}}", expected: "1.2.3"},
+		{tmpl: "{{stripped_version|trimv}}", expected: "1.2.3"},
+		{tmpl: "{{source|trimv}}", expected: "endor"},
+		{tmpl: "{{plugin_name|upper|title}}", expected: "MY-PLUGIN"},
+		{tmpl: "{{plugin_name|lower|title}}", expected: "My-plugin"},
+		{tmpl: "{{ version | trimv | upper }}", expected: "1.2.3"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.tmpl, func(t *testing.T) {
+			platform := c.platform
+			if platform.OS == "" {
+				platform = Platform{OS: "linux", Arch: "amd64"}
+			}
+
+			result, err := executeTemplate(c.tmpl, plg, platform)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if result != c.expected {
+				t.Errorf("expected %q but got %q", c.expected, result)
+			}
+		})
+	}
+}
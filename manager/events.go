@@ -0,0 +1,248 @@
+package manager
+
+import (
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+// EventType describes the type of an event published by the manager.
+type EventType string
+
+// All event types published by the manager.
+const (
+	EventFetchStarted    EventType = "fetch-started"
+	EventFetchFinished   EventType = "fetch-finished"
+	EventUpdateAvailable EventType = "update-available"
+	EventInstallStarted  EventType = "install-started"
+	EventInstallProgress EventType = "install-progress"
+	EventInstallFinished EventType = "install-finished"
+	EventUninstalled     EventType = "uninstalled"
+	EventRolledBack      EventType = "rolled-back"
+)
+
+// maxQueuedEvents is the maximum number of events queued per subscription.
+const maxQueuedEvents = 256
+
+type (
+	// Event is published by the manager whenever something interesting
+	// happens. Use a type switch to access the concrete event.
+	Event interface {
+		Type() EventType
+	}
+
+	// FetchStarted is published when the manager starts to fetch all
+	// repositories.
+	FetchStarted struct{}
+
+	// FetchFinished is published for each repository after fetching
+	// repositories finished. Err is set if the repository could not be
+	// fetched. Repository is empty if Err cannot be attributed to a single
+	// repository.
+	FetchFinished struct {
+		Repository string
+		Err        error
+	}
+
+	// UpdateAvailable is published after repositories have been fetched and
+	// updates for installed plugins are available.
+	UpdateAvailable struct {
+		Updates []structs.AvailableUpdate
+	}
+
+	// InstallStarted is published when the manager starts to install or
+	// update a plugin. PreviousVersion is only set for updates.
+	InstallStarted struct {
+		Plugin          string
+		Version         string
+		PreviousVersion string
+	}
+
+	// InstallProgress is published while the artifact of a plugin is
+	// downloaded. Total is -1 if the size of the artifact is unknown.
+	InstallProgress struct {
+		Plugin     string
+		Version    string
+		Downloaded int64
+		Total      int64
+	}
+
+	// InstallFinished is published when a plugin installation or update
+	// finished. Err is set if the installation failed or, wrapping
+	// ErrRollbackUnsupported, if an update has been installed but could
+	// not be verified.
+	InstallFinished struct {
+		Plugin          string
+		Version         string
+		PreviousVersion string
+		Err             error
+	}
+
+	// Uninstalled is published after a plugin has been uninstalled.
+	Uninstalled struct {
+		Plugin  string
+		Version string
+	}
+
+	// RolledBack is published when a plugin update failed and the
+	// previous version has been restored.
+	RolledBack struct {
+		structs.Rollback
+	}
+
+	// Subscription receives events published by the manager. Events are
+	// queued per subscription so slow subscribers never block the manager.
+	// Queued InstallProgress events of the same plugin are coalesced and if
+	// the queue is full, the oldest event is dropped.
+	Subscription struct {
+		mng *Manager
+		ch  chan Event
+
+		l      sync.Mutex
+		queue  []Event
+		notify chan struct{}
+
+		done chan struct{}
+		once sync.Once
+	}
+)
+
+func (FetchStarted) Type() EventType    { return EventFetchStarted }
+func (FetchFinished) Type() EventType   { return EventFetchFinished }
+func (UpdateAvailable) Type() EventType { return EventUpdateAvailable }
+func (InstallStarted) Type() EventType  { return EventInstallStarted }
+func (InstallProgress) Type() EventType { return EventInstallProgress }
+func (InstallFinished) Type() EventType { return EventInstallFinished }
+func (Uninstalled) Type() EventType     { return EventUninstalled }
+func (RolledBack) Type() EventType      { return EventRolledBack }
+
+// Subscribe returns a new subscription that receives all events published
+// by the manager until Unsubscribe is called.
+func (mng *Manager) Subscribe() *Subscription {
+	sub := &Subscription{
+		mng:    mng,
+		ch:     make(chan Event),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	mng.subsLock.Lock()
+	mng.subscriptions = append(mng.subscriptions, sub)
+	mng.subsLock.Unlock()
+
+	go sub.run()
+
+	return sub
+}
+
+// Events returns the channel on which events are delivered. The channel
+// is closed after Unsubscribe has been called.
+func (sub *Subscription) Events() <-chan Event {
+	return sub.ch
+}
+
+// Unsubscribe stops the delivery of events and closes the events channel.
+// Events that have not yet been received are dropped.
+func (sub *Subscription) Unsubscribe() {
+	sub.once.Do(func() {
+		sub.mng.subsLock.Lock()
+		defer sub.mng.subsLock.Unlock()
+
+		for idx, s := range sub.mng.subscriptions {
+			if s == sub {
+				sub.mng.subscriptions = append(sub.mng.subscriptions[:idx], sub.mng.subscriptions[idx+1:]...)
+
+				break
+			}
+		}
+
+		close(sub.done)
+	})
+}
+
+func (sub *Subscription) push(evt Event) {
+	sub.l.Lock()
+	sub.enqueueLocked(evt)
+	sub.l.Unlock()
+
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *Subscription) enqueueLocked(evt Event) {
+	if progress, ok := evt.(InstallProgress); ok {
+		for idx, queued := range sub.queue {
+			if q, ok := queued.(InstallProgress); ok && q.Plugin == progress.Plugin && q.Version == progress.Version {
+				sub.queue[idx] = progress
+
+				return
+			}
+		}
+	}
+
+	if len(sub.queue) >= maxQueuedEvents {
+		// prefer to drop progress events as they are superseded by later
+		// events anyway.
+		drop := 0
+		for idx, queued := range sub.queue {
+			if _, ok := queued.(InstallProgress); ok {
+				drop = idx
+
+				break
+			}
+		}
+
+		if _, ok := sub.queue[drop].(InstallProgress); !ok {
+			hclog.L().Warn("subscriber queue is full, dropping event", "event", sub.queue[drop].Type())
+		}
+
+		copy(sub.queue[drop:], sub.queue[drop+1:])
+		sub.queue[len(sub.queue)-1] = nil
+		sub.queue = sub.queue[:len(sub.queue)-1]
+	}
+
+	sub.queue = append(sub.queue, evt)
+}
+
+func (sub *Subscription) run() {
+	defer close(sub.ch)
+
+	for {
+		sub.l.Lock()
+		if len(sub.queue) == 0 {
+			sub.l.Unlock()
+
+			select {
+			case <-sub.notify:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+
+		evt := sub.queue[0]
+		sub.queue[0] = nil
+		sub.queue = sub.queue[1:]
+		sub.l.Unlock()
+
+		select {
+		case sub.ch <- evt:
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+// publish queues evt for delivery to all subscribers. It never blocks and
+// is safe to call while holding the manager lock.
+func (mng *Manager) publish(evt Event) {
+	mng.subsLock.Lock()
+	defer mng.subsLock.Unlock()
+
+	for _, sub := range mng.subscriptions {
+		sub.push(evt)
+	}
+}
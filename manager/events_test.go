@@ -0,0 +1,178 @@
+package manager
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// receive returns the next event of sub or fails the test after a second.
+func receive(t *testing.T, sub *Subscription) (Event, bool) {
+	t.Helper()
+
+	select {
+	case evt, ok := <-sub.Events():
+		return evt, ok
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+
+		return nil, false
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	mng := NewManager("", nil, nil, nil)
+
+	sub := mng.Subscribe()
+	other := mng.Subscribe()
+	defer other.Unsubscribe()
+
+	published := []Event{
+		FetchStarted{},
+		FetchFinished{Repository: "main"},
+		InstallStarted{Plugin: "test", Version: "1.0.0"},
+	}
+	for _, evt := range published {
+		mng.publish(evt)
+	}
+
+	// every subscription receives all events in order.
+	for _, s := range []*Subscription{sub, other} {
+		for _, expected := range published {
+			if evt, _ := receive(t, s); !reflect.DeepEqual(evt, expected) {
+				t.Errorf("expected %#v but got %#v", expected, evt)
+			}
+		}
+	}
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+
+	// publishing must not block on or deliver to removed subscriptions.
+	mng.publish(FetchStarted{})
+
+	if _, ok := receive(t, sub); ok {
+		t.Errorf("expected the events channel to be closed")
+	}
+
+	if len(mng.subscriptions) != 1 || mng.subscriptions[0] != other {
+		t.Errorf("expected only the other subscription to be left")
+	}
+
+	if evt, _ := receive(t, other); evt != (FetchStarted{}) {
+		t.Errorf("expected FetchStarted but got %#v", evt)
+	}
+}
+
+func TestSubscriptionCoalescesProgress(t *testing.T) {
+	progress := func(plugin, version string, downloaded int64) InstallProgress {
+		return InstallProgress{Plugin: plugin, Version: version, Downloaded: downloaded, Total: 100}
+	}
+
+	cases := []struct {
+		name     string
+		queue    []Event
+		evt      Event
+		expected []Event
+	}{
+		{
+			name:     "same plugin and version",
+			queue:    []Event{InstallStarted{Plugin: "a"}, progress("a", "1.0.0", 10), progress("b", "1.0.0", 10)},
+			evt:      progress("a", "1.0.0", 20),
+			expected: []Event{InstallStarted{Plugin: "a"}, progress("a", "1.0.0", 20), progress("b", "1.0.0", 10)},
+		},
+		{
+			name:     "different version",
+			queue:    []Event{progress("a", "1.0.0", 10)},
+			evt:      progress("a", "2.0.0", 20),
+			expected: []Event{progress("a", "1.0.0", 10), progress("a", "2.0.0", 20)},
+		},
+		{
+			name:     "different plugin",
+			queue:    []Event{progress("a", "1.0.0", 10)},
+			evt:      progress("b", "1.0.0", 20),
+			expected: []Event{progress("a", "1.0.0", 10), progress("b", "1.0.0", 20)},
+		},
+		{
+			name:     "other events are never coalesced",
+			queue:    []Event{FetchStarted{}},
+			evt:      FetchStarted{},
+			expected: []Event{FetchStarted{}, FetchStarted{}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sub := &Subscription{queue: c.queue}
+			sub.enqueueLocked(c.evt)
+
+			if !reflect.DeepEqual(sub.queue, c.expected) {
+				t.Errorf("expected queue %v but got %v", c.expected, sub.queue)
+			}
+		})
+	}
+}
+
+func TestSubscriptionOverflow(t *testing.T) {
+	t.Run("drops progress first", func(t *testing.T) {
+		sub := new(Subscription)
+		for idx := 0; idx < maxQueuedEvents; idx++ {
+			var evt Event = FetchFinished{Repository: strconv.Itoa(idx)}
+			if idx == 10 {
+				evt = InstallProgress{Plugin: "test"}
+			}
+
+			sub.enqueueLocked(evt)
+		}
+
+		sub.enqueueLocked(FetchFinished{Repository: "new"})
+		if len(sub.queue) != maxQueuedEvents {
+			t.Fatalf("expected %d queued events but got %d", maxQueuedEvents, len(sub.queue))
+		}
+
+		for _, evt := range sub.queue {
+			if _, ok := evt.(InstallProgress); ok {
+				t.Fatalf("expected the progress event to be dropped")
+			}
+		}
+
+		// without progress events the oldest one is dropped.
+		sub.enqueueLocked(FetchFinished{Repository: "newer"})
+		if len(sub.queue) != maxQueuedEvents {
+			t.Fatalf("expected %d queued events but got %d", maxQueuedEvents, len(sub.queue))
+		}
+
+		if first := sub.queue[0]; first != (FetchFinished{Repository: "1"}) {
+			t.Errorf("expected the oldest event to be dropped but got %#v", first)
+		}
+
+		if last := sub.queue[len(sub.queue)-1]; last != (FetchFinished{Repository: "newer"}) {
+			t.Errorf("expected the newest event to be queued but got %#v", last)
+		}
+	})
+
+	t.Run("slow subscriber", func(t *testing.T) {
+		mng := NewManager("", nil, nil, nil)
+
+		sub := mng.Subscribe()
+		defer sub.Unsubscribe()
+
+		total := maxQueuedEvents + 10
+		for idx := 0; idx < total; idx++ {
+			mng.publish(FetchFinished{Repository: strconv.Itoa(idx)})
+		}
+
+		// the subscription may hold one more event than queued while
+		// waiting for the receiver.
+		var received []Event
+		for len(received) == 0 || received[len(received)-1] != (FetchFinished{Repository: strconv.Itoa(total - 1)}) {
+			evt, _ := receive(t, sub)
+			received = append(received, evt)
+		}
+
+		if len(received) > maxQueuedEvents+1 {
+			t.Errorf("expected at most %d events but got %d", maxQueuedEvents+1, len(received))
+		}
+	})
+}
@@ -5,17 +5,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/renameio"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/hashicorp/hcl/v2/hclsimple"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/ppacher/portmaster-plugin-registry/installer"
+	"github.com/ppacher/portmaster-plugin-registry/registry"
 	"github.com/ppacher/portmaster-plugin-registry/structs"
 	"github.com/safing/portmaster/plugin/shared"
 	"github.com/safing/portmaster/plugin/shared/pluginmanager"
@@ -24,15 +28,71 @@ import (
 
 var (
 	ErrUnknownPlugin = errors.New("unknown plugin")
+	ErrNotInstalled  = errors.New("plugin is not installed")
+	ErrInstalled     = errors.New("plugin is already installed, use UpdatePlugin instead")
+	ErrNoUpdate      = errors.New("no update available")
+	ErrRolledBack    = errors.New("update rolled back")
+	ErrNotStarted    = errors.New("manager has not been started")
+
+	// ErrUnregisterUnsupported is returned if a plugin needs to be stopped
+	// but the plugin manager does not implement PluginUnregisterer.
+	ErrUnregisterUnsupported = errors.New("the Portmaster does not support stopping plugins")
+
+	// ErrRollbackUnsupported is returned, wrapped, by UpdatePlugin if the
+	// update has been installed but the plugin manager cannot report whether
+	// the updated plugin started. The update is not rolled back if the
+	// updated plugin fails.
+	ErrRollbackUnsupported = errors.New("rollback unsupported: the Portmaster cannot report whether the updated plugin started")
+)
+
+const (
+	// pluginStartTimeout is the maximum time to wait for an updated plugin to
+	// start before the update is rolled back.
+	pluginStartTimeout = 30 * time.Second
+
+	// minRetryBackoff and maxRetryBackoff define the boundaries for the
+	// exponential backoff used when fetching repositories fails.
+	minRetryBackoff = 5 * time.Second
+	maxRetryBackoff = 10 * time.Minute
+
+	// progressInterval is the minimum interval between two InstallProgress
+	// events of the same installation.
+	progressInterval = 250 * time.Millisecond
+
+	// DefaultRefreshInterval is the default interval at which the manager
+	// fetches repositories and checks for plugin updates.
+	DefaultRefreshInterval = 10 * time.Minute
 )
 
 type (
 	// PluginProvider describes the minimum interface required by the manager.
-	// It's implemented by registry.Registry.
+	// It's implemented by registry.Registry. ByName and ByNameVersion must
+	// accept names in the form of repo/plugin to select a specific repository.
 	PluginProvider interface {
-		Fetch() error
+		Fetch(ctx context.Context) error
 		ByName(string) (structs.PluginDesc, bool)
-		UpdateAvailable(name, version string) (string, error)
+		ByNameVersion(name, constraint string) (structs.PluginDesc, bool, error)
+		UpdateAvailable(plg structs.InstalledPlugin) (string, error)
+		Repositories() []structs.Repository
+	}
+
+	// PluginUnregisterer may be implemented by the pluginmanager.Service
+	// passed to NewManager if it supports stopping and removing plugins
+	// from the Portmaster.
+	PluginUnregisterer interface {
+		UnregisterPlugin(ctx context.Context, name string) error
+	}
+
+	// PluginHealthChecker may be implemented by the pluginmanager.Service
+	// passed to NewManager if it can report whether a plugin has been started
+	// successfully. CheckPlugin should block until the plugin is running
+	// and return an error if the plugin failed to start or crashed.
+	//
+	// If supported together with PluginUnregisterer, the manager rolls back
+	// plugin updates that fail to start. Note that the pluginmanager.Service
+	// provided by the Portmaster implements neither of them, see UpdatePlugin.
+	PluginHealthChecker interface {
+		CheckPlugin(ctx context.Context, name string) error
 	}
 
 	// Manager manages installed plugins.
@@ -42,47 +102,59 @@ type (
 		installer     installer.Installer
 		pluginManager pluginmanager.Service
 
-		l                 sync.RWMutex
-		started           bool
-		installedPlugins  []structs.InstalledPlugin
-		onFetchDone       []func(err error)
-		onUpdateAvailable []func(updates []structs.AvailableUpdate)
+		refreshInterval time.Duration
+
+		// refreshLock makes sure only one refresh is executed at a time.
+		refreshLock sync.Mutex
+
+		// wg tracks all background goroutines of the manager.
+		wg sync.WaitGroup
+
+		// retrying is set to 1 while failed fetches are retried.
+		retrying uint32
+
+		subsLock      sync.Mutex
+		subscriptions []*Subscription
+
+		l                sync.RWMutex
+		started          bool
+		installedPlugins []structs.InstalledPlugin
 	}
+
+	// Option configures optional settings of the Manager.
+	Option func(mng *Manager)
 )
 
+// WithRefreshInterval configures the interval at which the manager fetches
+// repositories and checks for plugin updates. Values less or equal to zero
+// select DefaultRefreshInterval.
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(mng *Manager) {
+		if interval <= 0 {
+			interval = DefaultRefreshInterval
+		}
+
+		mng.refreshInterval = interval
+	}
+}
+
 // NewManager returns a new plugin manager that stores state information in
 // stateFile and uses inst for plugin installations and reg for available plugin
 // lookups.
-func NewManager(stateFile string, inst installer.Installer, reg PluginProvider, service pluginmanager.Service) *Manager {
-	return &Manager{
-		stateFile:     stateFile,
-		installer:     inst,
-		provider:      reg,
-		pluginManager: service,
+func NewManager(stateFile string, inst installer.Installer, reg PluginProvider, service pluginmanager.Service, opts ...Option) *Manager {
+	mng := &Manager{
+		stateFile:       stateFile,
+		installer:       inst,
+		provider:        reg,
+		pluginManager:   service,
+		refreshInterval: DefaultRefreshInterval,
 	}
-}
 
-// OnFetchDone registers a callback function that is invoked when the
-// plugin provided fetched new repository data.
-//
-// The provided error indicates if the fetch was successful or not.
-func (mng *Manager) OnFetchDone(fn func(error)) {
-	mng.l.Lock()
-	defer mng.l.Unlock()
-
-	mng.onFetchDone = append(mng.onFetchDone, fn)
-}
-
-// OnUpdateAvailable registers a new callback function that is executed
-// when new updates are available.
-//
-// This is only ever fired after OnFetchDone and only if fetching repositories
-// was successful.
-func (mng *Manager) OnUpdateAvailable(fn func([]structs.AvailableUpdate)) {
-	mng.l.Lock()
-	defer mng.l.Unlock()
+	for _, opt := range opts {
+		opt(mng)
+	}
 
-	mng.onUpdateAvailable = append(mng.onUpdateAvailable, fn)
+	return mng
 }
 
 // InstalledPlugins returns a list of all installed plugins.
@@ -99,71 +171,238 @@ func (mng *Manager) InstalledPlugins() []structs.InstalledPlugin {
 // Start starts the plugin manager. The manager will shutdown as soon as
 // ctx is cancelled.
 func (mng *Manager) Start(ctx context.Context) error {
+	if ok, err := mng.init(ctx); !ok {
+		return err
+	}
+
+	// failing to fetch repositories is not fatal as the provider falls back
+	// to the last known-good index of each repository. We retry with
+	// exponential backoff until fetching succeeds.
+	if err := mng.fetch(ctx); err != nil && ctx.Err() == nil {
+		hclog.L().Error("failed to fetch repositories", "error", err)
+
+		mng.startRetry(ctx)
+	}
+
+	mng.wg.Add(1)
+	go mng.scheduleRefresh(ctx)
+
+	return nil
+}
+
+// installProgress returns an installer.ProgressFunc that publishes
+// InstallProgress events for plg. Events are published at most every
+// progressInterval and once the download is complete.
+func (mng *Manager) installProgress(plg structs.PluginDesc) installer.ProgressFunc {
+	var lastPublished time.Time
+
+	return func(downloaded, total int64) {
+		if downloaded != total && time.Since(lastPublished) < progressInterval {
+			return
+		}
+		lastPublished = time.Now()
+
+		mng.publish(InstallProgress{
+			Plugin:     plg.Name,
+			Version:    plg.Version,
+			Downloaded: downloaded,
+			Total:      total,
+		})
+	}
+}
+
+// init loads the state file and registers all installed plugins. It reports
+// false if the manager failed to initialize or has already been started.
+func (mng *Manager) init(ctx context.Context) (bool, error) {
 	mng.l.Lock()
 	defer mng.l.Unlock()
 
 	if mng.started {
-		return nil
+		return false, nil
 	}
-	mng.started = true
 
 	if err := mng.loadStateFile(ctx); err != nil {
-		return err
+		return false, err
 	}
+	mng.started = true
 
+	// failing to register some plugins is not fatal, we still want to
+	// manage all the others.
 	if err := mng.registerAllPlugins(ctx); err != nil {
-		return fmt.Errorf("failed to register plugins: %w", err)
+		hclog.L().Error("failed to register plugins", "error", err)
+	}
+
+	return true, nil
+}
+
+// InstallPlugin installs a new plugin, updates the state file, registers it in the
+// Portmaster.
+//
+// If constraint is set, the latest version of the plugin that matches the
+// github.com/hashicorp/go-version constraint is installed. Otherwise, the
+// latest version available is used.
+//
+// Use repo/plugin as the name to install the plugin from a specific repository
+// instead of the highest-priority one. The repository is remembered so future
+// updates are installed from the same repository.
+//
+// If the state file cannot be updated, the downloaded plugin is removed again.
+//
+// ErrInstalled is returned if the plugin is already installed, regardless of
+// the installed version.
+func (mng *Manager) InstallPlugin(ctx context.Context, name string, constraint string) (err error) {
+	plg, ok := mng.provider.ByName(name)
+	if constraint != "" {
+		var err error
+		plg, ok, err = mng.provider.ByNameVersion(name, constraint)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !ok {
+		return ErrUnknownPlugin
+	}
+
+	mng.l.RLock()
+	_, installed := mng.findInstalled(plg.Name)
+	mng.l.RUnlock()
+
+	if installed {
+		return ErrInstalled
 	}
 
-	if err := mng.provider.Fetch(); err != nil {
+	mng.publish(InstallStarted{
+		Plugin:  plg.Name,
+		Version: plg.Version,
+	})
+	defer func() {
+		mng.publish(InstallFinished{
+			Plugin:  plg.Name,
+			Version: plg.Version,
+			Err:     err,
+		})
+	}()
+
+	// make sure we support all plugin types before downloading anything.
+	if _, err := pluginTypesToProto(plg.PluginTypes); err != nil {
 		return err
 	}
 
-	upds := mng.detectUpdates()
-	for _, cb := range mng.onUpdateAvailable {
-		cb(upds)
+	path, err := mng.installer.InstallPlugin(ctx, plg, mng.installProgress(plg))
+	if err != nil {
+		return fmt.Errorf("failed to install: %w", err)
 	}
 
-	ticker := time.NewTicker(10 * time.Minute)
-	go func() {
-		defer ticker.Stop()
-	L:
-		for {
+	newPlugin := structs.InstalledPlugin{
+		PluginDesc: plg,
+		Path:       path,
+	}
 
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				mng.update()
-				break L
+	mng.l.Lock()
+	defer mng.l.Unlock()
+
+	// the plugin might have been installed concurrently while we were
+	// downloading it.
+	if current, installed := mng.findInstalled(plg.Name); installed {
+		if current.Path != path {
+			if err := mng.installer.UninstallPlugin(ctx, newPlugin); err != nil {
+				hclog.L().Error("failed to remove plugin binary", "plugin", plg.Name, "path", path, "error", err)
 			}
 		}
 
-		// try to read one more time from the ticker channel
-		// to make sure we don't leak any goroutines blocking on ticker.C <- ...
-		select {
-		case <-ticker.C:
-		default:
+		return ErrInstalled
+	}
+
+	mng.installedPlugins = append(mng.installedPlugins, newPlugin)
+
+	if err := mng.saveStateFile(); err != nil {
+		mng.installedPlugins = mng.installedPlugins[:len(mng.installedPlugins)-1]
+
+		if err := mng.installer.UninstallPlugin(ctx, newPlugin); err != nil {
+			hclog.L().Error("failed to remove plugin binary", "plugin", plg.Name, "path", path, "error", err)
 		}
-	}()
+
+		return fmt.Errorf("failed to update state file: %w", err)
+	}
+
+	if err := mng.registerPlugin(ctx, plg); err != nil {
+		return fmt.Errorf("failed to register plugin in Portmaster: %w", err)
+	}
 
 	return nil
 }
 
-// InstallPlugin installs a new plugin, updates the state file, registers it in the
-// Portmaster.
-func (mng *Manager) InstallPlugin(ctx context.Context, name string) error {
-	plg, ok := mng.provider.ByName(name)
+// UpdatePlugin updates an installed plugin to the latest version available.
+// The update is installed from the repository the plugin has been installed
+// from unless that repository has been removed. The new version is downloaded and installed next to the current one before
+// the state file is updated and the plugin is re-registered in the Portmaster.
+// Finally, the binary of the previous version is removed.
+//
+// Re-registering the plugin requires the plugin manager to implement
+// PluginUnregisterer. If the updated plugin cannot be registered or, if the
+// plugin manager implements PluginHealthChecker, fails to start, the update
+// is rolled back and an error wrapping ErrRolledBack is returned.
+//
+// Otherwise, the update is kept but an error wrapping ErrRollbackUnsupported
+// is returned. Without PluginUnregisterer the plugin is not re-registered and
+// the update takes effect once the Portmaster registers all plugins on its
+// next start.
+//
+// ErrNoUpdate is returned if the installed version is already the latest one.
+func (mng *Manager) UpdatePlugin(ctx context.Context, name string) (err error) {
+	mng.l.RLock()
+	current, ok := mng.findInstalled(name)
+	mng.l.RUnlock()
+
 	if !ok {
-		return ErrUnknownPlugin
+		return ErrNotInstalled
 	}
 
-	pluginTypes, err := pluginTypesToProto(plg.PluginTypes)
+	newVersion, err := mng.provider.UpdateAvailable(current)
 	if err != nil {
 		return err
 	}
 
-	path, err := mng.installer.InstallPlugin(ctx, plg)
+	if newVersion == "" {
+		return ErrNoUpdate
+	}
+
+	// stay on the repository the plugin has been installed from unless
+	// it has been removed.
+	lookupName := name
+	if current.Repository != "" && mng.hasRepository(current.Repository) {
+		lookupName = current.Repository + "/" + name
+	}
+
+	plg, ok, err := mng.provider.ByNameVersion(lookupName, "= "+newVersion)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrUnknownPlugin
+	}
+
+	mng.publish(InstallStarted{
+		Plugin:          plg.Name,
+		Version:         plg.Version,
+		PreviousVersion: current.Version,
+	})
+	defer func() {
+		mng.publish(InstallFinished{
+			Plugin:          plg.Name,
+			Version:         plg.Version,
+			PreviousVersion: current.Version,
+			Err:             err,
+		})
+	}()
+
+	if _, err := pluginTypesToProto(plg.PluginTypes); err != nil {
+		return err
+	}
+
+	path, err := mng.installer.InstallPlugin(ctx, plg, mng.installProgress(plg))
 	if err != nil {
 		return fmt.Errorf("failed to install: %w", err)
 	}
@@ -171,48 +410,401 @@ func (mng *Manager) InstallPlugin(ctx context.Context, name string) error {
 	mng.l.Lock()
 	defer mng.l.Unlock()
 
-	mng.installedPlugins = append(mng.installedPlugins, structs.InstalledPlugin{
-		PluginDesc: plg,
-		Path:       path,
-	})
+	idx := mng.installedIndex(name)
+	if idx == -1 {
+		// the plugin has been uninstalled while we were downloading the
+		// update.
+		return ErrNotInstalled
+	}
+
+	previous := mng.installedPlugins[idx]
+	mng.installedPlugins[idx] = structs.InstalledPlugin{
+		PluginDesc:    plg,
+		Path:          path,
+		UpdatePolicy:  previous.UpdatePolicy,
+		FollowChannel: previous.FollowChannel,
+	}
 
 	if err := mng.saveStateFile(); err != nil {
+		mng.installedPlugins[idx] = previous
+
 		return fmt.Errorf("failed to update state file: %w", err)
 	}
 
-	if err := mng.pluginManager.RegisterPlugin(ctx, &proto.PluginConfig{
-		Name:             name,
-		PluginTypes:      pluginTypes,
-		Privileged:       plg.Privileged,
-		DisableAutostart: true,
-	}); err != nil {
-		return fmt.Errorf("failed to register plugin in Portmaster: %w", err)
+	var checked bool
+
+	restarted, startErr := mng.reregisterPlugin(ctx, mng.installedPlugins[idx])
+	if startErr != nil {
+		startErr = fmt.Errorf("failed to register plugin in Portmaster: %w", startErr)
+	} else if restarted {
+		// don't block other operations while waiting for the plugin to
+		// start.
+		mng.l.Unlock()
+		checked, startErr = mng.waitForPluginStart(ctx, name)
+		mng.l.Lock()
+
+		idx = mng.installedIndex(name)
+		if idx == -1 || mng.installedPlugins[idx].Path != path {
+			// the plugin has been uninstalled or changed in the meantime
+			// so there's nothing left to commit or roll back.
+			return fmt.Errorf("plugin %s has been modified while updating", name)
+		}
+	}
+
+	if startErr != nil {
+		hclog.L().Error("updated plugin failed to start, rolling back", "plugin", name, "version", plg.Version, "error", startErr)
+
+		if rollbackErr := mng.rollback(ctx, idx, previous); rollbackErr != nil {
+			return fmt.Errorf("updated plugin failed to start (%s) and rollback failed: %w", startErr, rollbackErr)
+		}
+
+		mng.publish(RolledBack{
+			Rollback: structs.Rollback{
+				Name:            name,
+				FailedVersion:   plg.Version,
+				RestoredVersion: previous.Version,
+				Error:           startErr.Error(),
+			},
+		})
+
+		return fmt.Errorf("%w to version %s: %s", ErrRolledBack, previous.Version, startErr)
+	}
+
+	// if the plugin has not been restarted the previous version keeps
+	// running from its already opened binary until the Portmaster restarts.
+	if previous.Path != path {
+		if err := mng.installer.UninstallPlugin(ctx, previous); err != nil {
+			hclog.L().Error("failed to remove previous plugin version", "plugin", name, "version", previous.Version, "error", err)
+		}
 	}
 
+	switch {
+	case !restarted:
+		hclog.L().Warn("plugin updated but cannot be restarted, the update takes effect on the next Portmaster start", "plugin", name, "previous-version", previous.Version, "version", plg.Version)
+
+		return fmt.Errorf("plugin %s updated to %s, the update takes effect on the next Portmaster start but %w", name, plg.Version, ErrRollbackUnsupported)
+
+	case !checked:
+		hclog.L().Warn("plugin updated but cannot be checked for a successful start", "plugin", name, "previous-version", previous.Version, "version", plg.Version)
+
+		return fmt.Errorf("plugin %s updated to %s but %w", name, plg.Version, ErrRollbackUnsupported)
+	}
+
+	hclog.L().Info("plugin updated successfully", "plugin", name, "previous-version", previous.Version, "version", plg.Version)
+
 	return nil
 }
 
-func (mng *Manager) update() {
-	err := mng.provider.Fetch()
+// SetUpdatePolicy configures the update policy of an installed plugin and
+// persists it in the state file. The policy must be a valid
+// github.com/hashicorp/go-version constraint, use UpdatePolicy.Constraint
+// to create one for common policies. An empty policy allows any update.
+func (mng *Manager) SetUpdatePolicy(name string, policy string) error {
+	if policy != "" {
+		if _, err := version.NewConstraint(policy); err != nil {
+			return fmt.Errorf("invalid update policy %q: %w", policy, err)
+		}
+	}
+
+	mng.l.Lock()
+	defer mng.l.Unlock()
 
+	idx := mng.installedIndex(name)
+	if idx == -1 {
+		return ErrNotInstalled
+	}
+
+	previous := mng.installedPlugins[idx].UpdatePolicy
+	mng.installedPlugins[idx].UpdatePolicy = policy
+
+	if err := mng.saveStateFile(); err != nil {
+		mng.installedPlugins[idx].UpdatePolicy = previous
+
+		return fmt.Errorf("failed to update state file: %w", err)
+	}
+
+	return nil
+}
+
+// SetFollowChannel configures the release channel that is followed for updates
+// of an installed plugin and persists it in the state file. If channel is empty,
+// the release channel of the plugin repository is followed.
+func (mng *Manager) SetFollowChannel(name string, channel string) error {
+	switch channel {
+	case "", structs.ChannelStable, structs.ChannelBeta, structs.ChannelNightly:
+	default:
+		return fmt.Errorf("unsupported release channel %q", channel)
+	}
+
+	mng.l.Lock()
+	defer mng.l.Unlock()
+
+	idx := mng.installedIndex(name)
+	if idx == -1 {
+		return ErrNotInstalled
+	}
+
+	previous := mng.installedPlugins[idx].FollowChannel
+	mng.installedPlugins[idx].FollowChannel = channel
+
+	if err := mng.saveStateFile(); err != nil {
+		mng.installedPlugins[idx].FollowChannel = previous
+
+		return fmt.Errorf("failed to update state file: %w", err)
+	}
+
+	return nil
+}
+
+// UninstallPlugin stops and unregisters the plugin from the Portmaster, removes
+// it from the state file and finally deletes the plugin binary and any temporary
+// artifacts.
+//
+// The state file is updated before the binary is deleted so a crash in between
+// only leaves an orphaned binary behind but never a state file entry that points
+// to a missing binary.
+//
+// ErrUnregisterUnsupported is returned, without touching the state file or the
+// plugin binary, if the plugin manager does not implement PluginUnregisterer as
+// the plugin would keep running from a deleted binary otherwise.
+func (mng *Manager) UninstallPlugin(ctx context.Context, name string) error {
+	mng.l.Lock()
+	defer mng.l.Unlock()
+
+	var (
+		removed   []structs.InstalledPlugin
+		remaining = make([]structs.InstalledPlugin, 0, len(mng.installedPlugins))
+	)
+
+	for _, plg := range mng.installedPlugins {
+		if plg.Name == name {
+			removed = append(removed, plg)
+		} else {
+			remaining = append(remaining, plg)
+		}
+	}
+
+	if len(removed) == 0 {
+		return ErrNotInstalled
+	}
+
+	unregisterer, ok := mng.pluginManager.(PluginUnregisterer)
+	if !ok {
+		return fmt.Errorf("failed to uninstall %s: %w", name, ErrUnregisterUnsupported)
+	}
+
+	if err := unregisterer.UnregisterPlugin(ctx, name); err != nil {
+		return fmt.Errorf("failed to unregister plugin from Portmaster: %w", err)
+	}
+
+	previous := mng.installedPlugins
+	mng.installedPlugins = remaining
+
+	if err := mng.saveStateFile(); err != nil {
+		mng.installedPlugins = previous
+
+		return fmt.Errorf("failed to update state file: %w", err)
+	}
+
+	multierr := new(multierror.Error)
+	for _, plg := range removed {
+		mng.publish(Uninstalled{
+			Plugin:  plg.Name,
+			Version: plg.Version,
+		})
+
+		if err := mng.installer.UninstallPlugin(ctx, plg); err != nil {
+			multierr.Errors = append(multierr.Errors, err)
+		}
+	}
+
+	return multierr.ErrorOrNil()
+}
+
+// Refresh fetches all repositories and checks for plugin updates. It blocks
+// until the refresh is finished and returns the fetch error, if any.
+func (mng *Manager) Refresh(ctx context.Context) error {
 	mng.l.RLock()
-	defer mng.l.RUnlock()
+	started := mng.started
+	mng.l.RUnlock()
 
-	for _, fn := range mng.onFetchDone {
-		fn(err)
+	if !started {
+		return ErrNotStarted
 	}
 
-	// we abort now if there was an error
-	if err != nil {
-		return
+	return mng.fetch(ctx)
+}
+
+// Wait blocks until all background goroutines of the manager have exited.
+// This happens after the context passed to Start has been cancelled.
+func (mng *Manager) Wait() {
+	mng.wg.Wait()
+}
+
+// scheduleRefresh periodically refreshes repositories until ctx is cancelled.
+func (mng *Manager) scheduleRefresh(ctx context.Context) {
+	defer mng.wg.Done()
+
+	ticker := time.NewTicker(mng.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mng.update(ctx)
+		}
+	}
+}
+
+// update fetches the provider and publishes the results. If fetching fails,
+// a retry with exponential backoff is started.
+func (mng *Manager) update(ctx context.Context) {
+	if err := mng.fetch(ctx); err != nil && ctx.Err() == nil {
+		mng.startRetry(ctx)
+	}
+}
+
+// fetch fetches the provider, publishes a FetchFinished event for each
+// repository and an UpdateAvailable event if updates are available. It
+// returns the fetch error, if any.
+//
+// Updates are detected even if some repositories failed as the provider
+// falls back to the last known-good index of those repositories.
+func (mng *Manager) fetch(ctx context.Context) error {
+	mng.refreshLock.Lock()
+	defer mng.refreshLock.Unlock()
+
+	mng.publish(FetchStarted{})
+
+	err := mng.provider.Fetch(ctx)
+
+	// don't report errors caused by shutting down
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
+	repoErrs, otherErr := splitRepositoryErrors(err)
+	for _, repo := range mng.provider.Repositories() {
+		mng.publish(FetchFinished{
+			Repository: repo.Name,
+			Err:        repoErrs[repo.Name],
+		})
+	}
+
+	if otherErr != nil {
+		mng.publish(FetchFinished{
+			Err: otherErr,
+		})
+	}
+
+	mng.l.RLock()
 	updates := mng.detectUpdates()
+	mng.l.RUnlock()
+
 	if len(updates) > 0 {
-		for _, fn := range mng.onUpdateAvailable {
-			fn(updates)
+		mng.publish(UpdateAvailable{
+			Updates: updates,
+		})
+	}
+
+	return err
+}
+
+// hasRepository reports whether the provider has a repository called name.
+func (mng *Manager) hasRepository(name string) bool {
+	for _, repo := range mng.provider.Repositories() {
+		if repo.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitRepositoryErrors splits err returned by PluginProvider.Fetch into
+// errors per repository and a remaining error that cannot be attributed to
+// a single repository.
+func splitRepositoryErrors(err error) (map[string]error, error) {
+	repoErrs := make(map[string]error)
+	if err == nil {
+		return repoErrs, nil
+	}
+
+	var errs []error
+
+	var merr *multierror.Error
+	if errors.As(err, &merr) {
+		errs = merr.Errors
+	} else {
+		errs = []error{err}
+	}
+
+	other := new(multierror.Error)
+	for _, err := range errs {
+		var repoErr *registry.RepositoryError
+		if errors.As(err, &repoErr) {
+			if prev, ok := repoErrs[repoErr.Repository]; ok {
+				repoErrs[repoErr.Repository] = multierror.Append(prev, repoErr.Err)
+			} else {
+				repoErrs[repoErr.Repository] = repoErr.Err
+			}
+
+			continue
 		}
+
+		other.Errors = append(other.Errors, err)
 	}
+
+	return repoErrs, other.ErrorOrNil()
+}
+
+// startRetry starts retrying to fetch the provider with exponential backoff
+// and jitter until fetching succeeds or ctx is cancelled. It's a no-op if
+// there's already a retry in progress.
+func (mng *Manager) startRetry(ctx context.Context) {
+	if !atomic.CompareAndSwapUint32(&mng.retrying, 0, 1) {
+		return
+	}
+
+	mng.wg.Add(1)
+	go func() {
+		defer mng.wg.Done()
+		defer atomic.StoreUint32(&mng.retrying, 0)
+
+		backoff := minRetryBackoff
+		for {
+			timer := time.NewTimer(withJitter(backoff))
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return
+			case <-timer.C:
+			}
+
+			err := mng.fetch(ctx)
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+
+			hclog.L().Error("failed to fetch repositories, retrying", "error", err, "backoff", backoff)
+
+			backoff *= 2
+			if backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+		}
+	}()
+}
+
+// withJitter returns d with a random jitter of up to +/- 20 percent applied.
+func withJitter(d time.Duration) time.Duration {
+	jitter := time.Duration(rand.Int63n(int64(d)/5*2+1)) - d/5
+
+	return d + jitter
 }
 
 // AvailableUpdates returns a list of available plugin updates.
@@ -238,7 +830,9 @@ func (mng *Manager) loadStateFile(ctx context.Context) error {
 		return err
 	}
 
-	if file.Version != "v1.0.0" {
+	switch file.Version {
+	case structs.StateFileVersion10, structs.StateFileVersion11:
+	default:
 		return fmt.Errorf("unsupported installed plugins file format %q", file.Version)
 	}
 
@@ -250,33 +844,114 @@ func (mng *Manager) loadStateFile(ctx context.Context) error {
 func (mng *Manager) registerAllPlugins(ctx context.Context) error {
 	multierr := new(multierror.Error)
 	for _, plg := range mng.installedPlugins {
-		protoTypes, err := pluginTypesToProto(plg.PluginTypes)
-		if err != nil {
-			multierr.Errors = append(multierr.Errors, fmt.Errorf("plugin %s: %w", plg.Name, err))
+		if err := mng.registerPlugin(ctx, plg.PluginDesc); err != nil {
+			multierr.Errors = append(multierr.Errors, fmt.Errorf("plugin %s: failed to register: %w", plg.Name, err))
 
 			continue
 		}
+	}
 
-		if err := mng.pluginManager.RegisterPlugin(ctx, &proto.PluginConfig{
-			Name:             plg.Name,
-			PluginTypes:      protoTypes,
-			Privileged:       plg.Privileged,
-			DisableAutostart: true,
-		}); err != nil {
-			multierr.Errors = append(multierr.Errors, fmt.Errorf("plugin %s: failed to register: %w", plg.Name, err))
+	return multierr.ErrorOrNil()
+}
 
-			continue
+func (mng *Manager) registerPlugin(ctx context.Context, plg structs.PluginDesc) error {
+	protoTypes, err := pluginTypesToProto(plg.PluginTypes)
+	if err != nil {
+		return err
+	}
+
+	return mng.pluginManager.RegisterPlugin(ctx, &proto.PluginConfig{
+		Name:             plg.Name,
+		PluginTypes:      protoTypes,
+		Privileged:       plg.Privileged,
+		DisableAutostart: true,
+	})
+}
+
+// reregisterPlugin unregisters plg from the Portmaster and registers it again
+// so the Portmaster picks up the new plugin binary. As registering a plugin
+// that is still registered is not supported, reregisterPlugin reports false
+// without doing anything if the plugin manager does not implement
+// PluginUnregisterer.
+func (mng *Manager) reregisterPlugin(ctx context.Context, plg structs.InstalledPlugin) (bool, error) {
+	unregisterer, ok := mng.pluginManager.(PluginUnregisterer)
+	if !ok {
+		return false, nil
+	}
+
+	if err := unregisterer.UnregisterPlugin(ctx, plg.Name); err != nil {
+		return true, fmt.Errorf("failed to unregister: %w", err)
+	}
+
+	return true, mng.registerPlugin(ctx, plg.PluginDesc)
+}
+
+// waitForPluginStart waits for the plugin name to start successfully if the
+// plugin manager implements PluginHealthChecker. Otherwise, it reports false
+// immediately. The caller must not hold mng.l.
+func (mng *Manager) waitForPluginStart(ctx context.Context, name string) (bool, error) {
+	checker, ok := mng.pluginManager.(PluginHealthChecker)
+	if !ok {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pluginStartTimeout)
+	defer cancel()
+
+	if err := checker.CheckPlugin(ctx, name); err != nil {
+		return true, fmt.Errorf("plugin failed to start: %w", err)
+	}
+
+	return true, nil
+}
+
+// rollback restores previous as the installed version at index idx, persists
+// the state file, re-registers the plugin and finally removes the binary of
+// the failed update. The caller must hold mng.l.
+func (mng *Manager) rollback(ctx context.Context, idx int, previous structs.InstalledPlugin) error {
+	failed := mng.installedPlugins[idx]
+	mng.installedPlugins[idx] = previous
+
+	if err := mng.saveStateFile(); err != nil {
+		return fmt.Errorf("failed to update state file: %w", err)
+	}
+
+	if _, err := mng.reregisterPlugin(ctx, previous); err != nil {
+		return fmt.Errorf("failed to register previous version in Portmaster: %w", err)
+	}
+
+	if failed.Path != previous.Path {
+		if err := mng.installer.UninstallPlugin(ctx, failed); err != nil {
+			hclog.L().Error("failed to remove failed plugin version", "plugin", failed.Name, "version", failed.Version, "error", err)
 		}
 	}
 
-	return multierr.ErrorOrNil()
+	return nil
+}
+
+func (mng *Manager) findInstalled(name string) (structs.InstalledPlugin, bool) {
+	if idx := mng.installedIndex(name); idx != -1 {
+		return mng.installedPlugins[idx], true
+	}
+
+	return structs.InstalledPlugin{}, false
+}
+
+func (mng *Manager) installedIndex(name string) int {
+	for idx, plg := range mng.installedPlugins {
+		if plg.Name == name {
+			return idx
+		}
+	}
+
+	return -1
 }
 
 func (mng *Manager) saveStateFile() error {
 	file := hclwrite.NewEmptyFile()
 
 	fileContent := structs.InstalledPluginsFile{
-		Version: "v1.0.0",
+		Version: structs.StateFileVersion11,
 		Plugins: mng.installedPlugins,
 	}
 
@@ -294,7 +969,7 @@ func (mng *Manager) detectUpdates() []structs.AvailableUpdate {
 	var updates []structs.AvailableUpdate
 
 	for _, installedPlugin := range mng.installedPlugins {
-		updatedVersion, err := mng.provider.UpdateAvailable(installedPlugin.Name, installedPlugin.Version)
+		updatedVersion, err := mng.provider.UpdateAvailable(installedPlugin)
 		if err != nil {
 			hclog.L().Error("failed to check for available updates", "plugin", installedPlugin.Name, "error", err)
 
@@ -0,0 +1,484 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/ppacher/portmaster-plugin-registry/installer"
+	"github.com/ppacher/portmaster-plugin-registry/registry"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+	"github.com/safing/portmaster/plugin/shared/pluginmanager"
+	"github.com/safing/portmaster/plugin/shared/proto"
+)
+
+type (
+	// fakeProvider serves all versions of the plugins in plugins and
+	// reports repos as the configured repositories.
+	fakeProvider struct {
+		plugins []structs.PluginDesc
+		repos   []structs.Repository
+	}
+
+	// fakeInstaller installs plugins by writing their version to a file
+	// in dir.
+	fakeInstaller struct {
+		dir string
+	}
+
+	// fakeService records all registered plugin versions. The version of a
+	// plugin is read from stateFile as the Portmaster only receives the
+	// plugin name. If registerErr contains the version of a plugin, it's
+	// returned when registering the plugin.
+	fakeService struct {
+		stateFile   string
+		registerErr map[string]error
+
+		l          sync.Mutex
+		registered []string
+	}
+
+	// fakeUnregisterService additionally implements PluginUnregisterer.
+	fakeUnregisterService struct {
+		*fakeService
+	}
+
+	// fakeHealthService additionally implements PluginHealthChecker and
+	// reports the error in startErr for the version of a plugin, if any.
+	fakeHealthService struct {
+		fakeUnregisterService
+		startErr map[string]error
+	}
+)
+
+func (provider *fakeProvider) Fetch(ctx context.Context) error { return nil }
+
+func (provider *fakeProvider) ByName(name string) (structs.PluginDesc, bool) {
+	return provider.latest(name, nil)
+}
+
+func (provider *fakeProvider) ByNameVersion(name, constraint string) (structs.PluginDesc, bool, error) {
+	c, err := version.NewConstraint(constraint)
+	if err != nil {
+		return structs.PluginDesc{}, false, err
+	}
+
+	plg, ok := provider.latest(name, c)
+
+	return plg, ok, nil
+}
+
+func (provider *fakeProvider) UpdateAvailable(installed structs.InstalledPlugin) (string, error) {
+	latest, ok := provider.latest(installed.Name, nil)
+	if !ok {
+		return "", ErrUnknownPlugin
+	}
+
+	if version.Must(version.NewVersion(latest.Version)).GreaterThan(version.Must(version.NewVersion(installed.Version))) {
+		return latest.Version, nil
+	}
+
+	return "", nil
+}
+
+func (provider *fakeProvider) Repositories() []structs.Repository { return provider.repos }
+
+func (provider *fakeProvider) latest(name string, c version.Constraints) (structs.PluginDesc, bool) {
+	var (
+		best        structs.PluginDesc
+		bestVersion *version.Version
+	)
+
+	repo, name := registry.SplitPluginName(name)
+
+	for _, plg := range provider.plugins {
+		v := version.Must(version.NewVersion(plg.Version))
+		if plg.Name != name || (repo != "" && plg.Repository != repo) || (c != nil && !c.Check(v)) {
+			continue
+		}
+
+		if bestVersion == nil || v.GreaterThan(bestVersion) {
+			best, bestVersion = plg, v
+		}
+	}
+
+	return best, bestVersion != nil
+}
+
+func (inst *fakeInstaller) InstallPlugin(ctx context.Context, plg structs.PluginDesc, progress installer.ProgressFunc) (string, error) {
+	path := filepath.Join(inst.dir, plg.Name+"-"+plg.Version)
+
+	return path, os.WriteFile(path, []byte(plg.Version), 0600)
+}
+
+func (inst *fakeInstaller) UninstallPlugin(ctx context.Context, plg structs.InstalledPlugin) error {
+	return os.Remove(plg.Path)
+}
+
+func (service *fakeService) RegisterPlugin(ctx context.Context, cfg *proto.PluginConfig) error {
+	v := service.installedVersion(cfg.Name)
+
+	service.l.Lock()
+	defer service.l.Unlock()
+
+	service.registered = append(service.registered, cfg.Name+"@"+v)
+
+	return service.registerErr[v]
+}
+
+func (service *fakeService) registrations() []string {
+	service.l.Lock()
+	defer service.l.Unlock()
+
+	return append([]string(nil), service.registered...)
+}
+
+// installedVersion returns the version of the plugin name stored in the
+// state file.
+func (service *fakeService) installedVersion(name string) string {
+	state := NewManager(service.stateFile, nil, nil, nil)
+	if err := state.loadStateFile(context.Background()); err != nil {
+		return ""
+	}
+
+	if plg, ok := state.findInstalled(name); ok {
+		return plg.Version
+	}
+
+	return ""
+}
+
+func (service fakeUnregisterService) UnregisterPlugin(ctx context.Context, name string) error {
+	return nil
+}
+
+func (service fakeHealthService) CheckPlugin(ctx context.Context, name string) error {
+	return service.startErr[service.installedVersion(name)]
+}
+
+// testPlugin returns the description of the plugin test in version v.
+func testPlugin(v string) structs.PluginDesc {
+	return structs.PluginDesc{
+		Name:    "test",
+		Version: v,
+	}
+}
+
+// newTestManager returns a started manager that uses pluginManager and has
+// the plugin test installed in version 1.0.0. Version 2.0.0 is available as
+// an update. service must be the fakeService used by pluginManager.
+func newTestManager(t *testing.T, service *fakeService, pluginManager pluginmanager.Service) (*Manager, *fakeInstaller) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	inst := &fakeInstaller{dir: t.TempDir()}
+	provider := &fakeProvider{
+		plugins: []structs.PluginDesc{testPlugin("1.0.0")},
+	}
+
+	service.stateFile = filepath.Join(t.TempDir(), "state.hcl")
+
+	mng := NewManager(service.stateFile, inst, provider, pluginManager)
+	t.Cleanup(func() {
+		cancel()
+		mng.Wait()
+	})
+
+	if err := mng.Start(ctx); err != nil {
+		t.Fatalf("failed to start manager: %s", err)
+	}
+
+	if err := mng.InstallPlugin(ctx, "test", ""); err != nil {
+		t.Fatalf("failed to install plugin: %s", err)
+	}
+
+	provider.plugins = append(provider.plugins, testPlugin("2.0.0"))
+
+	return mng, inst
+}
+
+// readStateFile returns the plugins stored in the state file of mng.
+func readStateFile(t *testing.T, mng *Manager) []structs.InstalledPlugin {
+	t.Helper()
+
+	check := NewManager(mng.stateFile, nil, nil, nil)
+	if err := check.loadStateFile(context.Background()); err != nil {
+		t.Fatalf("failed to load state file: %s", err)
+	}
+
+	return check.installedPlugins
+}
+
+func TestUninstallPlugin(t *testing.T) {
+	t.Run("unregister supported", func(t *testing.T) {
+		service := new(fakeService)
+		mng, _ := newTestManager(t, service, fakeUnregisterService{service})
+
+		path := mng.InstalledPlugins()[0].Path
+
+		if err := mng.UninstallPlugin(context.Background(), "test"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if len(mng.InstalledPlugins()) != 0 || len(readStateFile(t, mng)) != 0 {
+			t.Errorf("expected plugin to be removed")
+		}
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected plugin binary to be removed (err=%v)", err)
+		}
+	})
+
+	t.Run("unregister unsupported", func(t *testing.T) {
+		service := new(fakeService)
+		mng, _ := newTestManager(t, service, service)
+
+		path := mng.InstalledPlugins()[0].Path
+
+		err := mng.UninstallPlugin(context.Background(), "test")
+		if !errors.Is(err, ErrUnregisterUnsupported) {
+			t.Fatalf("expected ErrUnregisterUnsupported but got %v", err)
+		}
+
+		if len(mng.InstalledPlugins()) != 1 || len(readStateFile(t, mng)) != 1 {
+			t.Errorf("expected plugin to stay installed")
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected plugin binary to be kept: %s", err)
+		}
+	})
+
+	t.Run("not installed", func(t *testing.T) {
+		service := new(fakeService)
+		mng, _ := newTestManager(t, service, fakeUnregisterService{service})
+
+		if err := mng.UninstallPlugin(context.Background(), "other"); !errors.Is(err, ErrNotInstalled) {
+			t.Fatalf("expected ErrNotInstalled but got %v", err)
+		}
+	})
+}
+
+func TestUpdatePlugin(t *testing.T) {
+	startErr := errors.New("plugin crashed")
+
+	cases := []struct {
+		name    string
+		service func(*fakeService) pluginmanager.Service
+
+		// expected is the error expected from UpdatePlugin, if any.
+		expected error
+
+		// registrations are the plugin registrations expected after
+		// installing version 1.0.0 and updating.
+		registrations []string
+	}{
+		{
+			name: "verified",
+			service: func(s *fakeService) pluginmanager.Service {
+				return fakeHealthService{fakeUnregisterService: fakeUnregisterService{s}}
+			},
+			registrations: []string{"test@1.0.0", "test@2.0.0"},
+		},
+		{
+			name: "failed to start",
+			service: func(s *fakeService) pluginmanager.Service {
+				return fakeHealthService{
+					fakeUnregisterService: fakeUnregisterService{s},
+					startErr:              map[string]error{"2.0.0": startErr},
+				}
+			},
+			expected:      ErrRolledBack,
+			registrations: []string{"test@1.0.0", "test@2.0.0", "test@1.0.0"},
+		},
+		{
+			name: "failed to register",
+			service: func(s *fakeService) pluginmanager.Service {
+				s.registerErr = map[string]error{"2.0.0": startErr}
+
+				return fakeUnregisterService{s}
+			},
+			expected:      ErrRolledBack,
+			registrations: []string{"test@1.0.0", "test@2.0.0", "test@1.0.0"},
+		},
+		{
+			name: "health check unsupported",
+			service: func(s *fakeService) pluginmanager.Service {
+				return fakeUnregisterService{s}
+			},
+			expected:      ErrRollbackUnsupported,
+			registrations: []string{"test@1.0.0", "test@2.0.0"},
+		},
+		{
+			name: "unregister unsupported",
+			service: func(s *fakeService) pluginmanager.Service {
+				return s
+			},
+			expected: ErrRollbackUnsupported,
+			// the plugin must not be registered twice.
+			registrations: []string{"test@1.0.0"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			service := new(fakeService)
+			mng, inst := newTestManager(t, service, c.service(service))
+
+			sub := mng.Subscribe()
+			defer sub.Unsubscribe()
+
+			err := mng.UpdatePlugin(context.Background(), "test")
+			switch {
+			case c.expected == nil && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case c.expected != nil && !errors.Is(err, c.expected):
+				t.Fatalf("expected %v but got %v", c.expected, err)
+			}
+
+			rolledBack := errors.Is(c.expected, ErrRolledBack)
+
+			expectedVersion, removedVersion := "2.0.0", "1.0.0"
+			if rolledBack {
+				expectedVersion, removedVersion = removedVersion, expectedVersion
+			}
+
+			// the installed plugin entry in memory and in the state file
+			for _, installed := range [][]structs.InstalledPlugin{mng.InstalledPlugins(), readStateFile(t, mng)} {
+				if len(installed) != 1 || installed[0].Version != expectedVersion {
+					t.Fatalf("expected version %s to be installed but got %+v", expectedVersion, installed)
+				}
+
+				if expectedPath := filepath.Join(inst.dir, "test-"+expectedVersion); installed[0].Path != expectedPath {
+					t.Errorf("expected path %s but got %s", expectedPath, installed[0].Path)
+				}
+			}
+
+			// the plugin binaries
+			if content, err := os.ReadFile(filepath.Join(inst.dir, "test-"+expectedVersion)); err != nil || string(content) != expectedVersion {
+				t.Errorf("expected binary of version %s to exist (content=%q, err=%v)", expectedVersion, content, err)
+			}
+
+			if _, err := os.Stat(filepath.Join(inst.dir, "test-"+removedVersion)); !os.IsNotExist(err) {
+				t.Errorf("expected binary of version %s to be removed (err=%v)", removedVersion, err)
+			}
+
+			if registrations := service.registrations(); !reflect.DeepEqual(registrations, c.registrations) {
+				t.Errorf("expected registrations %v but got %v", c.registrations, registrations)
+			}
+
+			// the published events
+			var rollback *structs.Rollback
+			for finished := false; !finished; {
+				select {
+				case evt := <-sub.Events():
+					switch evt := evt.(type) {
+					case RolledBack:
+						rollback = &evt.Rollback
+					case InstallFinished:
+						finished = true
+
+						if !errors.Is(evt.Err, c.expected) || (c.expected == nil && evt.Err != nil) {
+							t.Errorf("expected InstallFinished with error %v but got %v", c.expected, evt.Err)
+						}
+					}
+				case <-time.After(time.Second):
+					t.Fatal("timeout waiting for InstallFinished")
+				}
+			}
+
+			switch {
+			case rolledBack && rollback == nil:
+				t.Errorf("expected a RolledBack event")
+			case rolledBack:
+				if rollback.Name != "test" || rollback.FailedVersion != "2.0.0" || rollback.RestoredVersion != "1.0.0" || rollback.Error == "" {
+					t.Errorf("unexpected rollback %+v", *rollback)
+				}
+			case rollback != nil:
+				t.Errorf("unexpected RolledBack event %+v", *rollback)
+			}
+		})
+	}
+}
+
+func TestUpdatePluginRepository(t *testing.T) {
+	cases := []struct {
+		name     string
+		repos    []string
+		expected string
+	}{
+		{name: "pinned", repos: []string{"main", "mirror"}, expected: "main"},
+		{name: "removed", repos: []string{"mirror"}, expected: "mirror"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			service := new(fakeService)
+			mng, _ := newTestManager(t, service, fakeHealthService{fakeUnregisterService: fakeUnregisterService{service}})
+
+			mng.l.Lock()
+			mng.installedPlugins[0].Repository = "main"
+			mng.l.Unlock()
+
+			provider := mng.provider.(*fakeProvider)
+			provider.plugins = nil
+			for _, name := range c.repos {
+				plg := testPlugin("2.0.0")
+				plg.Repository = name
+
+				provider.plugins = append(provider.plugins, plg)
+				provider.repos = append(provider.repos, structs.Repository{Name: name})
+			}
+
+			if err := mng.UpdatePlugin(context.Background(), "test"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if installed := readStateFile(t, mng); len(installed) != 1 || installed[0].Repository != c.expected {
+				t.Errorf("expected test to be installed from %s but got %+v", c.expected, installed)
+			}
+		})
+	}
+}
+
+func TestInstallPluginStateFileError(t *testing.T) {
+	service := new(fakeService)
+	mng, inst := newTestManager(t, service, service)
+
+	provider := mng.provider.(*fakeProvider)
+	provider.plugins = append(provider.plugins, structs.PluginDesc{
+		Name:    "other",
+		Version: "1.0.0",
+	})
+
+	// replacing a non-empty directory always fails, even for root.
+	if err := os.Remove(mng.stateFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(mng.stateFile, "blocker"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mng.InstallPlugin(context.Background(), "other", ""); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if installed := mng.InstalledPlugins(); len(installed) != 1 || installed[0].Name != "test" {
+		t.Errorf("expected only test to be installed but got %+v", installed)
+	}
+
+	if _, err := os.Stat(filepath.Join(inst.dir, "other-1.0.0")); !os.IsNotExist(err) {
+		t.Errorf("expected plugin binary to be removed (err=%v)", err)
+	}
+
+	if registrations := service.registrations(); !reflect.DeepEqual(registrations, []string{"test@1.0.0"}) {
+		t.Errorf("expected other to not be registered but got %v", registrations)
+	}
+}
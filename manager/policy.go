@@ -0,0 +1,50 @@
+package manager
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-version"
+)
+
+// UpdatePolicy defines a common update policy for installed plugins.
+type UpdatePolicy string
+
+// Supported update policies.
+const (
+	// PolicyPin pins the plugin to the exact version installed.
+	PolicyPin UpdatePolicy = "pin"
+
+	// PolicyPatch allows only patch updates of the installed version.
+	PolicyPatch UpdatePolicy = "patch"
+
+	// PolicyMinor allows minor and patch updates of the installed version.
+	PolicyMinor UpdatePolicy = "minor"
+
+	// PolicyAny allows any update.
+	PolicyAny UpdatePolicy = "any"
+)
+
+// Constraint returns the go-version constraint that implements the update policy
+// for a plugin installed at currentVersion. An empty constraint is returned for
+// PolicyAny.
+func (policy UpdatePolicy) Constraint(currentVersion string) (string, error) {
+	current, err := version.NewSemver(currentVersion)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse current version %q: %w", currentVersion, err)
+	}
+
+	segments := current.Segments()
+
+	switch policy {
+	case PolicyPin:
+		return "= " + current.String(), nil
+	case PolicyPatch:
+		return fmt.Sprintf("~> %d.%d.%d", segments[0], segments[1], segments[2]), nil
+	case PolicyMinor:
+		return fmt.Sprintf("~> %d.%d", segments[0], segments[1]), nil
+	case PolicyAny:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported update policy %q", policy)
+	}
+}
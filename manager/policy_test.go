@@ -0,0 +1,43 @@
+package manager
+
+import "testing"
+
+func TestUpdatePolicyConstraint(t *testing.T) {
+	cases := []struct {
+		policy   UpdatePolicy
+		current  string
+		expected string
+		invalid  bool
+	}{
+		{policy: PolicyPin, current: "1.2.3", expected: "= 1.2.3"},
+		{policy: PolicyPin, current: "v1.2.3-beta.1", expected: "= 1.2.3-beta.1"},
+		{policy: PolicyPatch, current: "1.2.3", expected: "~> 1.2.3"},
+		{policy: PolicyPatch, current: "1.2", expected: "~> 1.2.0"},
+		{policy: PolicyMinor, current: "1.2.3", expected: "~> 1.2"},
+		{policy: PolicyAny, current: "1.2.3", expected: ""},
+		{policy: "latest", current: "1.2.3", invalid: true},
+		{policy: PolicyPatch, current: "one", invalid: true},
+	}
+
+	for _, c := range cases {
+		t.Run(string(c.policy)+" "+c.current, func(t *testing.T) {
+			constraint, err := c.policy.Constraint(c.current)
+
+			if c.invalid {
+				if err == nil {
+					t.Errorf("expected an error but got %q", constraint)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if constraint != c.expected {
+				t.Errorf("expected %q but got %q", c.expected, constraint)
+			}
+		})
+	}
+}
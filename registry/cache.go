@@ -0,0 +1,155 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/renameio"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+type (
+	// indexCache persists the last known-good index file of each repository
+	// together with the HTTP caching headers required for conditional
+	// requests.
+	indexCache struct {
+		dir string
+	}
+
+	// cacheMeta holds meta data about a cached index file.
+	cacheMeta struct {
+		// URL is the repository URL the index has been fetched from.
+		URL string `json:"url"`
+
+		// Filename is the name of the index file as served by the
+		// repository. It's only used to detect the index format, see
+		// indexFile for the name of the cached file.
+		Filename string `json:"filename"`
+
+		// ETag holds the value of the ETag header returned by the
+		// server, if any.
+		ETag string `json:"etag,omitempty"`
+
+		// LastModified holds the value of the Last-Modified header returned
+		// by the server, if any.
+		LastModified string `json:"lastModified,omitempty"`
+
+		// FetchedAt is the time the index file has been fetched.
+		FetchedAt time.Time `json:"fetchedAt"`
+	}
+
+	// cachedIndex is a raw index file loaded from the cache.
+	cachedIndex struct {
+		meta      cacheMeta
+		blob      []byte
+		signature []byte
+	}
+)
+
+const (
+	cacheMetaFile      = "meta.json"
+	cacheSignatureFile = "index.sig"
+)
+
+// repoDir returns the cache directory for repo. The directory is keyed by
+// the repository name and URL so changing the URL of a repository never
+// uses stale data.
+func (cache *indexCache) repoDir(repo structs.Repository) string {
+	sum := sha256.Sum256([]byte(repo.Name + "\x00" + repo.URL))
+
+	return filepath.Join(cache.dir, hex.EncodeToString(sum[:16]))
+}
+
+// indexFile returns the name of the cached index file. The name is derived
+// from the full URL as the last path segment of a URL might be empty or
+// shared with other paths. The extension of Filename is kept so the format
+// of the index can still be detected.
+func (meta cacheMeta) indexFile() string {
+	sum := sha256.Sum256([]byte(meta.URL))
+
+	return hex.EncodeToString(sum[:16]) + filepath.Ext(meta.Filename)
+}
+
+// load loads the cached index for repo. If there is no cached index for
+// repo nil is returned.
+func (cache *indexCache) load(repo structs.Repository) (*cachedIndex, error) {
+	if cache == nil {
+		return nil, nil
+	}
+
+	dir := cache.repoDir(repo)
+
+	metaBlob, err := os.ReadFile(filepath.Join(dir, cacheMetaFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var entry cachedIndex
+	if err := json.Unmarshal(metaBlob, &entry.meta); err != nil {
+		return nil, fmt.Errorf("failed to parse cache meta data: %w", err)
+	}
+
+	entry.blob, err = os.ReadFile(filepath.Join(dir, entry.meta.indexFile()))
+	if err != nil {
+		// caches written by older versions used a different file name.
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to read cached index: %w", err)
+	}
+
+	entry.signature, err = os.ReadFile(filepath.Join(dir, cacheSignatureFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read cached signature: %w", err)
+	}
+
+	return &entry, nil
+}
+
+// store stores entry as the last known-good index for repo. The meta data
+// file is written last so a crash never leaves a meta file that points to
+// a partially written index.
+func (cache *indexCache) store(repo structs.Repository, entry *cachedIndex) error {
+	if cache == nil {
+		return nil
+	}
+
+	dir := cache.repoDir(repo)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	if err := renameio.WriteFile(filepath.Join(dir, entry.meta.indexFile()), entry.blob, 0600); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	sigFile := filepath.Join(dir, cacheSignatureFile)
+	if len(entry.signature) > 0 {
+		if err := renameio.WriteFile(sigFile, entry.signature, 0600); err != nil {
+			return fmt.Errorf("failed to write signature: %w", err)
+		}
+	} else if err := os.Remove(sigFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove signature: %w", err)
+	}
+
+	metaBlob, err := json.Marshal(entry.meta)
+	if err != nil {
+		return err
+	}
+
+	if err := renameio.WriteFile(filepath.Join(dir, cacheMetaFile), metaBlob, 0600); err != nil {
+		return fmt.Errorf("failed to write cache meta data: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+// cacheTestServer serves an index that contains the plugin test in version.
+// It supports conditional requests using ETag and records the If-None-Match
+// header of all requests.
+type cacheTestServer struct {
+	l           sync.Mutex
+	version     string
+	failing     bool
+	ifNoneMatch []string
+}
+
+func (srv *cacheTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.l.Lock()
+	defer srv.l.Unlock()
+
+	srv.ifNoneMatch = append(srv.ifNoneMatch, r.Header.Get("If-None-Match"))
+
+	if srv.failing {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	etag := `"` + srv.version + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	_ = json.NewEncoder(w).Encode(testIndex(testPlugin("test", releases(srv.version)...)))
+}
+
+func (srv *cacheTestServer) set(version string, failing bool) {
+	srv.l.Lock()
+	defer srv.l.Unlock()
+
+	srv.version = version
+	srv.failing = failing
+}
+
+// lastIfNoneMatch returns the If-None-Match header of the last request.
+func (srv *cacheTestServer) lastIfNoneMatch() string {
+	srv.l.Lock()
+	defer srv.l.Unlock()
+
+	return srv.ifNoneMatch[len(srv.ifNoneMatch)-1]
+}
+
+// fetchVersion fetches reg and returns the version of the plugin test or an
+// empty string if it's not available.
+func fetchVersion(t *testing.T, reg *Registry) (string, error) {
+	t.Helper()
+
+	err := reg.Fetch(context.Background())
+
+	plg, ok := reg.ByName("test")
+	if !ok {
+		return "", err
+	}
+
+	return plg.Version, err
+}
+
+// newCacheTestRegistry creates a registry that uses cacheDir and contains the
+// repository main at url.
+func newCacheTestRegistry(t *testing.T, cacheDir string, url string) *Registry {
+	t.Helper()
+
+	reg := NewRegistry(cacheDir)
+	if err := reg.AddRepository(structs.Repository{Name: "main", URL: url}); err != nil {
+		t.Fatal(err)
+	}
+
+	return reg
+}
+
+func TestFetchUsesCachedIndex(t *testing.T) {
+	handler := &cacheTestServer{version: "1.0.0"}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	cacheDir := t.TempDir()
+
+	if v, err := fetchVersion(t, newCacheTestRegistry(t, cacheDir, srv.URL+"/index.json")); err != nil || v != "1.0.0" {
+		t.Fatalf("expected version 1.0.0 but got %q (err=%v)", v, err)
+	}
+
+	handler.set("1.0.0", true)
+
+	// a new registry, e.g. after a restart, uses the cached index if the
+	// repository cannot be reached.
+	v, err := fetchVersion(t, newCacheTestRegistry(t, cacheDir, srv.URL+"/index.json"))
+	if err == nil {
+		t.Errorf("expected the failed repository to be reported")
+	}
+	if v != "1.0.0" {
+		t.Errorf("expected the cached index to be used but got version %q", v)
+	}
+
+	// the cache is keyed by the repository URL.
+	if v, _ := fetchVersion(t, newCacheTestRegistry(t, cacheDir, srv.URL+"/other.json")); v != "" {
+		t.Errorf("expected the cached index of another URL to be ignored but got version %q", v)
+	}
+
+	// without a cache directory there's nothing to fall back to.
+	if v, _ := fetchVersion(t, newCacheTestRegistry(t, "", srv.URL+"/index.json")); v != "" {
+		t.Errorf("expected no index without a cache but got version %q", v)
+	}
+}
+
+func TestFetchConditionalRequests(t *testing.T) {
+	handler := &cacheTestServer{version: "1.0.0"}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	cacheDir := t.TempDir()
+	reg := newCacheTestRegistry(t, cacheDir, srv.URL+"/index.json")
+
+	cases := []struct {
+		name        string
+		reg         *Registry
+		version     string
+		ifNoneMatch string
+	}{
+		{name: "initial fetch", reg: reg, version: "1.0.0"},
+		{name: "not modified", reg: reg, version: "1.0.0", ifNoneMatch: `"1.0.0"`},
+		{name: "not modified after restart", reg: newCacheTestRegistry(t, cacheDir, srv.URL+"/index.json"), version: "1.0.0", ifNoneMatch: `"1.0.0"`},
+		{name: "modified", reg: reg, version: "2.0.0", ifNoneMatch: `"1.0.0"`},
+		{name: "not modified after update", reg: reg, version: "2.0.0", ifNoneMatch: `"2.0.0"`},
+		{name: "without cache", reg: newCacheTestRegistry(t, "", srv.URL+"/index.json"), version: "2.0.0"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			handler.set(c.version, false)
+
+			v, err := fetchVersion(t, c.reg)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if v != c.version {
+				t.Errorf("expected version %s but got %q", c.version, v)
+			}
+
+			if header := handler.lastIfNoneMatch(); header != c.ifNoneMatch {
+				t.Errorf("expected If-None-Match %q but got %q", c.ifNoneMatch, header)
+			}
+		})
+	}
+}
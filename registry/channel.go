@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-version"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+// channelRanks holds the stability rank of all supported release channels.
+// Lower ranks are more stable.
+var channelRanks = map[string]int{
+	structs.ChannelStable:  0,
+	structs.ChannelBeta:    1,
+	structs.ChannelNightly: 2,
+}
+
+// ValidateChannel returns an error if channel is not a supported release
+// channel. An empty channel is valid and defaults to the stable channel.
+func ValidateChannel(channel string) error {
+	if channel == "" {
+		return nil
+	}
+
+	if _, ok := channelRanks[channel]; !ok {
+		return fmt.Errorf("unsupported release channel %q", channel)
+	}
+
+	return nil
+}
+
+// ReleaseChannel returns the release channel of plg. If plg does not specify
+// a channel, versions with a semver pre-release tag belong to the beta channel
+// and all other versions to the stable channel.
+func ReleaseChannel(plg structs.PluginDesc) string {
+	if plg.Channel != "" {
+		return plg.Channel
+	}
+
+	v, err := version.NewSemver(plg.Version)
+	if err == nil && v.Prerelease() != "" {
+		return structs.ChannelBeta
+	}
+
+	return structs.ChannelStable
+}
+
+// inChannel reports whether plg is released in the channel selected or in
+// a more stable one.
+func inChannel(plg structs.PluginDesc, selected string) bool {
+	if selected == "" {
+		selected = structs.ChannelStable
+	}
+
+	selectedRank, ok := channelRanks[selected]
+	if !ok {
+		return false
+	}
+
+	rank, ok := channelRanks[ReleaseChannel(plg)]
+	if !ok {
+		return false
+	}
+
+	return rank <= selectedRank
+}
@@ -0,0 +1,221 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/go-getter/v2"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+// maxIndexSize is the maximum size of index and signature files downloaded
+// via HTTP.
+const maxIndexSize = 32 << 20
+
+// downloadResult is the result of downloading a file using downloadFile.
+type downloadResult struct {
+	blob         []byte
+	filename     string
+	etag         string
+	lastModified string
+	notModified  bool
+}
+
+func (reg *Registry) fetchIndex(ctx context.Context, repo structs.Repository) (*structs.RepositoryIndex, []string, error) {
+	cached, err := reg.cache.load(repo)
+	if err != nil {
+		hclog.L().Warn("failed to load cached index", "repository", repo.Name, "error", err)
+	}
+
+	var cachedMeta *cacheMeta
+	if cached != nil {
+		cachedMeta = &cached.meta
+	}
+
+	res, err := downloadFile(ctx, repo.URL, cachedMeta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry := cached
+	if res.notModified {
+		hclog.L().Debug("repository index not modified", "repository", repo.Name)
+	} else {
+		entry = &cachedIndex{
+			meta: cacheMeta{
+				URL:          repo.URL,
+				Filename:     res.filename,
+				ETag:         res.etag,
+				LastModified: res.lastModified,
+			},
+			blob: res.blob,
+		}
+	}
+	entry.meta.FetchedAt = time.Now()
+
+	if len(repo.TrustedKeys) > 0 {
+		sigURL := repo.SignatureURL
+		if sigURL == "" {
+			sigURL = repo.URL + ".sig"
+		}
+
+		sig, err := downloadFile(ctx, sigURL, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: failed to download signature: %s", ErrUnsignedIndex, err)
+		}
+
+		entry.signature = sig.blob
+	}
+
+	index, warnings, err := decodeIndexEntry(repo, entry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := reg.cache.store(repo, entry); err != nil {
+		hclog.L().Warn("failed to update index cache", "repository", repo.Name, "error", err)
+	}
+
+	return index, warnings, nil
+}
+
+// loadCachedIndex returns the last known-good index of repo from the index cache.
+// If there is no cached index nil is returned.
+func (reg *Registry) loadCachedIndex(repo structs.Repository) (*structs.RepositoryIndex, []string, error) {
+	cached, err := reg.cache.load(repo)
+	if err != nil || cached == nil {
+		return nil, nil, err
+	}
+
+	return decodeIndexEntry(repo, cached)
+}
+
+// decodeIndexEntry verifies the signature of a raw index file, if required by repo,
+// and finally decodes and validates the index. It returns the validation
+// warnings of the index.
+func decodeIndexEntry(repo structs.Repository, entry *cachedIndex) (*structs.RepositoryIndex, []string, error) {
+	var warnings []string
+
+	if len(repo.TrustedKeys) > 0 {
+		if err := VerifyIndex(repo.TrustedKeys, entry.blob, string(entry.signature)); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		warnings = append(warnings, "repository does not define trusted keys, skipping index signature verification")
+	}
+
+	index, err := DecodeIndex(entry.meta.Filename, bytes.NewReader(entry.blob))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := ResolveRelativeURLs(repo.URL, index); err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve relative URLs: %w", err)
+	}
+
+	indexWarnings, err := ValidateIndex(index)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return index, append(warnings, indexWarnings...), nil
+}
+
+// downloadFile downloads the file at src. HTTP and HTTPS URLs are downloaded
+// directly and, if cached is set, a conditional request is sent to the server.
+// All other sources are downloaded using go-getter.
+func downloadFile(ctx context.Context, src string, cached *cacheMeta) (*downloadResult, error) {
+	u, err := url.Parse(src)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return downloadHTTP(ctx, u, cached)
+	}
+
+	tempDir, err := os.MkdirTemp("", "index-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	res, err := new(getter.Client).Get(ctx, &getter.Request{
+		Src: src,
+		Dst: tempDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := os.ReadFile(res.Dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return &downloadResult{
+		blob:     blob,
+		filename: filepath.Base(res.Dst),
+	}, nil
+}
+
+func downloadHTTP(ctx context.Context, u *url.URL, cached *cacheMeta) (*downloadResult, error) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached != nil && cached.URL == u.String() {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	cli := retryablehttp.NewClient()
+	cli.RetryMax = 3
+	cli.Logger = hclog.L().Named("http")
+
+	res, err := cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if cached != nil {
+			return &downloadResult{notModified: true}, nil
+		}
+
+		return nil, fmt.Errorf("unexpected response status %s", res.Status)
+	default:
+		return nil, fmt.Errorf("unexpected response status %s", res.Status)
+	}
+
+	blob, err := io.ReadAll(io.LimitReader(res.Body, maxIndexSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if len(blob) > maxIndexSize {
+		return nil, fmt.Errorf("response exceeds maximum size of %d bytes", maxIndexSize)
+	}
+
+	return &downloadResult{
+		blob:         blob,
+		filename:     path.Base(u.Path),
+		etag:         res.Header.Get("ETag"),
+		lastModified: res.Header.Get("Last-Modified"),
+	}, nil
+}
@@ -0,0 +1,148 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+// repositoryErrors returns the errors reported by Fetch keyed by repository
+// name.
+func repositoryErrors(t *testing.T, err error) map[string]error {
+	t.Helper()
+
+	var merr *multierror.Error
+	if !errors.As(err, &merr) {
+		t.Fatalf("expected a *multierror.Error but got %v", err)
+	}
+
+	errs := make(map[string]error, len(merr.Errors))
+	for _, err := range merr.Errors {
+		var repoErr *RepositoryError
+		if !errors.As(err, &repoErr) {
+			t.Fatalf("expected a *RepositoryError but got %v", err)
+		}
+
+		errs[repoErr.Repository] = repoErr.Err
+	}
+
+	return errs
+}
+
+func TestFetchIsolatesRepositories(t *testing.T) {
+	defer func(timeout time.Duration) {
+		FetchTimeout = timeout
+	}(FetchTimeout)
+	FetchTimeout = 200 * time.Millisecond
+
+	done := make(chan struct{})
+	defer close(done)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/good.json":
+			_ = json.NewEncoder(w).Encode(testIndex(testPlugin("good", releases("1.0.0")...)))
+		case "/slow.json":
+			// never answer so the request runs into FetchTimeout.
+			select {
+			case <-r.Context().Done():
+			case <-done:
+			}
+		case "/invalid.json":
+			_ = json.NewEncoder(w).Encode(testIndex(testPlugin("invalid", releases("one")...)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	reg := NewRegistry("")
+	for idx, name := range []string{"good", "slow", "missing", "invalid"} {
+		if err := reg.AddRepository(structs.Repository{Name: name, URL: srv.URL + "/" + name + ".json", Priority: idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	errs := repositoryErrors(t, reg.Fetch(context.Background()))
+
+	// all repositories are fetched concurrently so the slow one must not
+	// delay the others.
+	if elapsed := time.Since(start); elapsed > 10*FetchTimeout {
+		t.Errorf("fetching took %s", elapsed)
+	}
+
+	if len(errs) != 3 {
+		t.Errorf("expected 3 failed repositories but got %v", errs)
+	}
+
+	if !errors.Is(errs["slow"], context.DeadlineExceeded) {
+		t.Errorf("expected slow repository to time out but got %v", errs["slow"])
+	}
+
+	for _, name := range []string{"missing", "invalid"} {
+		if errs[name] == nil {
+			t.Errorf("expected repository %s to fail", name)
+		}
+	}
+
+	if _, ok := reg.ByName("good"); !ok {
+		t.Errorf("expected plugins of the good repository to be available")
+	}
+
+	if _, ok := reg.ByName("invalid"); ok {
+		t.Errorf("expected plugins of the invalid repository to be unavailable")
+	}
+}
+
+func TestFetchKeepsLastIndex(t *testing.T) {
+	var (
+		failing  int32
+		requests int32
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		if atomic.LoadInt32(&failing) == 1 {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(testIndex(testPlugin("test", releases("1.0.0")...)))
+	}))
+	defer srv.Close()
+
+	reg := NewRegistry("")
+	if err := reg.AddRepository(structs.Repository{Name: "main", URL: srv.URL + "/index.json"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reg.Fetch(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+
+	errs := repositoryErrors(t, reg.Fetch(context.Background()))
+	if errs["main"] == nil {
+		t.Errorf("expected the failed repository to be reported")
+	}
+
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("expected the index to be requested again")
+	}
+
+	if plg, ok := reg.ByName("test"); !ok || plg.Version != "1.0.0" {
+		t.Errorf("expected the last index to be used but got %+v", plg)
+	}
+}
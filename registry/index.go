@@ -1,22 +1,30 @@
 package registry
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/ghodss/yaml"
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/hcl/v2/hclsimple"
+	"github.com/ppacher/portmaster-plugin-registry/installer"
 	"github.com/ppacher/portmaster-plugin-registry/structs"
 )
 
 // DecodeIndex decodes a repository index file from reader. The path is required to detect
 // the correct encoding.
 //
-// Supported file extensions are .yaml, .json and .hcl.
+// Supported file extensions are .yaml, .json and .hcl. The Repository of all
+// plugins is always empty.
 func DecodeIndex(path string, reader io.Reader) (*structs.RepositoryIndex, error) {
 	ext := filepath.Ext(path)
 
@@ -49,18 +57,44 @@ func DecodeIndex(path string, reader io.Reader) (*structs.RepositoryIndex, error
 		return nil, fmt.Errorf("unsupported repository index format %q", ext)
 	}
 
+	// the repository of a plugin is set by the registry, an index must
+	// not be able to claim plugins of other repositories.
+	for idx := range repo.Plugins {
+		repo.Plugins[idx].Repository = ""
+	}
+
 	return &repo, nil
 }
 
+// Supported index file versions.
+const (
+	IndexVersion10 = "v1.0.0"
+	IndexVersion11 = "v1.1.0"
+	IndexVersion12 = "v1.2.0"
+)
+
+// Supported values for structs.Download.Libc.
+const (
+	LibcGlibc = "glibc"
+	LibcMusl  = "musl"
+)
+
 // ValidateIndex validates all plugin configurations in index and returns a list
 // of validation errors. A non-nil error is always of type *multierror.Error.
 //
-// If no errors are found, nil is returned.
-func ValidateIndex(index *structs.RepositoryIndex) error {
-	var errs = new(multierror.Error)
+// If no errors are found, nil is returned. Issues that do not render the
+// index invalid, like plugins without artifact checksums, are returned as
+// warnings so callers decide whether and how often to report them.
+func ValidateIndex(index *structs.RepositoryIndex) ([]string, error) {
+	var (
+		errs     = new(multierror.Error)
+		warnings []string
+	)
 
-	if index.Meta.Version != "v1.0.0" {
-		return fmt.Errorf("unsupported index version %q", index.Meta.Version)
+	switch index.Meta.Version {
+	case IndexVersion10, IndexVersion11, IndexVersion12:
+	default:
+		return nil, fmt.Errorf("unsupported index version %q", index.Meta.Version)
 	}
 
 	seenPlugins := make(map[string]struct{})
@@ -74,54 +108,471 @@ func ValidateIndex(index *structs.RepositoryIndex) error {
 			continue
 		}
 
+		if strings.Contains(plg.Name, "/") {
+			plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("plugin name must not contain a slash"))
+		}
+
 		if _, ok := seenPlugins[plg.Name]; ok {
 			plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("duplicated plugin name"))
 		}
+		seenPlugins[plg.Name] = struct{}{}
 
-		hasArtifact := false
-		if plg.ArtifactTemplate != "" {
-			hasArtifact = true
+		if index.Meta.Version != IndexVersion12 && definesDownloads(plg) {
+			plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("download blocks require index version %s", IndexVersion12))
 		}
 
-		for _, a := range plg.Artifacts {
-			isValid := true
+		if index.Meta.Version != IndexVersion12 && (len(plg.OSNames) > 0 || len(plg.ArchNames) > 0 || len(plg.Extensions) > 0) {
+			plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("os_names, arch_names and extensions require index version %s", IndexVersion12))
+		}
 
-			if a.OS == "" {
-				plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("artifact OS must be specified"))
-				isValid = false
+		if index.Meta.Version != IndexVersion12 && definesArchiveFormat(plg) {
+			plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("archive formats require index version %s", IndexVersion12))
+		}
+
+		if len(plg.Releases) == 0 {
+			releaseErrs, hasDigest := validatePluginRelease(plg)
+			plgErrs.Errors = append(plgErrs.Errors, releaseErrs...)
+
+			if !hasDigest {
+				warnings = append(warnings, fmt.Sprintf("plugin %s: no artifact checksums specified, downloads cannot be verified", plg.Name))
+			}
+		} else {
+			if index.Meta.Version == IndexVersion10 {
+				plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("releases require index version %s", IndexVersion11))
 			}
 
-			if a.AMD64 == "" && a.ARM == "" && a.ARM64 == "" && a.I386 == "" {
-				plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("artifact %q: no download URL defined", a.OS))
+			if plg.Version != "" {
+				plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("version must not be specified if releases are defined"))
+			}
+
+			seenVersions := make(map[string]struct{})
+			for _, release := range expandReleases(plg) {
+				if _, ok := seenVersions[release.Version]; ok {
+					plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("release %s: duplicated release version", release.Version))
+				}
+				seenVersions[release.Version] = struct{}{}
+
+				releaseErrs, hasDigest := validatePluginRelease(release)
+				for _, err := range releaseErrs {
+					plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("release %s: %w", release.Version, err))
+				}
+
+				if !hasDigest {
+					warnings = append(warnings, fmt.Sprintf("plugin %s: release %s: no artifact checksums specified, downloads cannot be verified", plg.Name, release.Version))
+				}
+			}
+		}
+
+		if err := plgErrs.ErrorOrNil(); err != nil {
+			errs.Errors = append(errs.Errors, fmt.Errorf("plugin %s: %w", plg.Name, plgErrs))
+		}
+	}
+
+	return warnings, errs.ErrorOrNil()
+}
+
+// ResolveRelativeURLs resolves all relative artifact and checksum URLs in index
+// against indexURL, which is the location the index has been loaded from. This
+// allows self-contained repository bundles, like the ones created by
+// registry-util mirror, to reference artifacts relative to the index file.
+//
+// Only URLs starting with ./ or ../ are considered relative. Everything else
+// is left untouched so go-getter shorthands like github.com/org/repo keep
+// working.
+//
+// If indexURL is a local path it is converted to a file:// URL.
+func ResolveRelativeURLs(indexURL string, index *structs.RepositoryIndex) error {
+	if !strings.Contains(indexURL, "://") {
+		abs, err := filepath.Abs(indexURL)
+		if err != nil {
+			return err
+		}
+
+		indexURL = "file://" + filepath.ToSlash(abs)
+	}
+
+	// strip any query parameters and the index file name
+	if idx := strings.IndexAny(indexURL, "?#"); idx >= 0 {
+		indexURL = indexURL[:idx]
+	}
+	baseURL := indexURL[:strings.LastIndex(indexURL, "/")+1]
+
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid index URL: %w", err)
+	}
+
+	errs := new(multierror.Error)
+	resolve := func(s *string) {
+		if !isRelativeURL(*s) {
+			return
+		}
+
+		// placeholders would be escaped by url.URL.String so only the
+		// part in front of the first placeholder is resolved.
+		rel, tmpl := *s, ""
+		if idx := strings.Index(rel, "{{"); idx >= 0 {
+			rel, tmpl = rel[:idx], rel[idx:]
+		}
+
+		ref, err := url.Parse(rel)
+		if err != nil {
+			errs.Errors = append(errs.Errors, fmt.Errorf("invalid relative URL %q: %w", *s, err))
+
+			return
+		}
+
+		*s = base.ResolveReference(ref).String() + tmpl
+	}
+
+	resolveArtifacts := func(artifacts []structs.Artifact, downloads []structs.Download) {
+		for idx := range artifacts {
+			resolve(&artifacts[idx].AMD64)
+			resolve(&artifacts[idx].ARM)
+			resolve(&artifacts[idx].ARM64)
+			resolve(&artifacts[idx].I386)
+		}
+
+		for idx := range downloads {
+			resolve(&downloads[idx].URL)
+		}
+	}
+
+	for idx := range index.Plugins {
+		plg := &index.Plugins[idx]
+
+		resolve(&plg.ArtifactTemplate)
+		resolve(&plg.Checksums)
+		resolveArtifacts(plg.Artifacts, plg.Downloads)
+
+		for relIdx := range plg.Releases {
+			release := &plg.Releases[relIdx]
+
+			resolve(&release.ArtifactTemplate)
+			resolve(&release.Checksums)
+			resolveArtifacts(release.Artifacts, release.Downloads)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// isRelativeURL reports whether s is an explicit relative path, that is, it
+// starts with ./ or ../.
+func isRelativeURL(s string) bool {
+	return strings.HasPrefix(s, "./") || strings.HasPrefix(s, "../")
+}
+
+// PluginReleases returns all releases of plg sorted by version, newest first.
+// If plg does not define any releases a slice containing only plg is returned.
+//
+// The returned plugin descriptions have the artifact definitions of the
+// respective release applied and do not define any releases themselves.
+func PluginReleases(plg structs.PluginDesc) ([]structs.PluginDesc, error) {
+	releases := expandReleases(plg)
+
+	versions := make(map[string]*version.Version, len(releases))
+	for _, release := range releases {
+		v, err := version.NewSemver(release.Version)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: invalid semver version %q: %w", plg.Name, release.Version, err)
+		}
+
+		versions[release.Version] = v
+	}
+
+	sort.SliceStable(releases, func(i, j int) bool {
+		return versions[releases[i].Version].GreaterThan(versions[releases[j].Version])
+	})
+
+	return releases, nil
+}
+
+func expandReleases(plg structs.PluginDesc) []structs.PluginDesc {
+	if len(plg.Releases) == 0 {
+		return []structs.PluginDesc{plg}
+	}
+
+	releases := make([]structs.PluginDesc, 0, len(plg.Releases))
+	for _, release := range plg.Releases {
+		desc := plg
+		desc.Releases = nil
+		desc.Version = release.Version
+
+		if release.ArtifactTemplate != "" {
+			desc.ArtifactTemplate = release.ArtifactTemplate
+		}
+
+		if release.ArchiveFile != "" {
+			desc.ArchiveFile = release.ArchiveFile
+		}
+
+		if release.ArchiveFormat != "" {
+			desc.ArchiveFormat = release.ArchiveFormat
+		}
+
+		if release.Checksums != "" {
+			desc.Checksums = release.Checksums
+		}
+
+		if len(release.Artifacts) > 0 || len(release.Downloads) > 0 {
+			desc.Artifacts = release.Artifacts
+			desc.Downloads = release.Downloads
+		}
+
+		if len(release.PluginTypes) > 0 {
+			desc.PluginTypes = release.PluginTypes
+		}
+
+		if release.Privileged != nil {
+			desc.Privileged = *release.Privileged
+		}
+
+		if release.Channel != "" {
+			desc.Channel = release.Channel
+		}
+
+		releases = append(releases, desc)
+	}
+
+	return releases
+}
+
+// validatePluginRelease validates the version and artifact definitions of
+// a single plugin release. It also reports whether the release specifies
+// any artifact checksums.
+func validatePluginRelease(plg structs.PluginDesc) ([]error, bool) {
+	var errs []error
+
+	hasArtifact := false
+	if plg.ArtifactTemplate != "" {
+		if err := installer.ValidateTemplate(plg.ArtifactTemplate); err != nil {
+			errs = append(errs, fmt.Errorf("artifact_template: %w", err))
+		} else {
+			hasArtifact = true
+		}
+	}
+
+	hasDigest := plg.Checksums != ""
+	if plg.Checksums != "" {
+		if err := installer.ValidateTemplate(plg.Checksums); err != nil {
+			errs = append(errs, fmt.Errorf("checksums: %w", err))
+		}
+	}
+
+	if err := installer.ValidateArchiveFormat(plg.ArchiveFormat); err != nil {
+		errs = append(errs, fmt.Errorf("archive_format: %w", err))
+	}
+
+	if plg.ArchiveFile != "" {
+		if err := installer.ValidateArchiveFile(plg.ArchiveFile); err != nil {
+			errs = append(errs, fmt.Errorf("archive_file: %w", err))
+		}
+	}
+
+	for _, a := range plg.Artifacts {
+		isValid := true
+
+		if a.OS == "" {
+			errs = append(errs, fmt.Errorf("artifact OS must be specified"))
+			isValid = false
+		}
+
+		if a.AMD64 == "" && a.ARM == "" && a.ARM64 == "" && a.I386 == "" {
+			errs = append(errs, fmt.Errorf("artifact %q: no download URL defined", a.OS))
+			isValid = false
+		}
+
+		if a.ArchiveFile != "" {
+			if err := installer.ValidateArchiveFile(a.ArchiveFile); err != nil {
+				errs = append(errs, fmt.Errorf("artifact %q: archive_file: %w", a.OS, err))
 				isValid = false
 			}
+		}
 
-			if isValid {
-				hasArtifact = true
+		for arch, digest := range a.SHA256 {
+			if err := validateDigest(digest, sha256.Size); err != nil {
+				errs = append(errs, fmt.Errorf("artifact %q: sha256 for %s: %w", a.OS, arch, err))
 			}
 		}
 
-		if !hasArtifact {
-			if len(plg.Artifacts) > 0 {
-				plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("no valid artifacts defined"))
-			} else {
-				plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("no artifacts defined"))
+		for arch, digest := range a.SHA512 {
+			if err := validateDigest(digest, sha512.Size); err != nil {
+				errs = append(errs, fmt.Errorf("artifact %q: sha512 for %s: %w", a.OS, arch, err))
 			}
 		}
 
-		if plg.Version == "" {
-			plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("version not specified"))
+		if isValid {
+			hasArtifact = true
+		}
+
+		if len(a.SHA256) > 0 || len(a.SHA512) > 0 {
+			hasDigest = true
+		}
+	}
+
+	seenDownloads := make(map[structs.Download]struct{})
+	for _, d := range plg.Downloads {
+		platform := d.OS + "/" + d.Arch
+		if d.Variant != "" {
+			platform += "/" + d.Variant
+		}
+
+		downloadErrs := validateDownload(d)
+		for _, err := range downloadErrs {
+			errs = append(errs, fmt.Errorf("download %q: %w", platform, err))
+		}
+
+		key := structs.Download{
+			OS:           d.OS,
+			Arch:         d.Arch,
+			Variant:      d.Variant,
+			Libc:         d.Libc,
+			MinOSVersion: d.MinOSVersion,
+		}
+		if _, ok := seenDownloads[key]; ok {
+			errs = append(errs, fmt.Errorf("download %q: duplicated download definition", platform))
+		}
+		seenDownloads[key] = struct{}{}
+
+		if len(downloadErrs) == 0 {
+			hasArtifact = true
+		}
+
+		if d.SHA256 != "" || d.SHA512 != "" {
+			hasDigest = true
+		}
+	}
+
+	if !hasArtifact {
+		if len(plg.Artifacts) > 0 {
+			errs = append(errs, fmt.Errorf("no valid artifacts defined"))
 		} else {
-			_, err := version.NewSemver(plg.Version)
-			if err != nil {
-				plgErrs.Errors = append(plgErrs.Errors, fmt.Errorf("invalid semver version: %w", err))
-			}
+			errs = append(errs, fmt.Errorf("no artifacts defined"))
 		}
+	}
 
-		if err := plgErrs.ErrorOrNil(); err != nil {
-			errs.Errors = append(errs.Errors, fmt.Errorf("plugin %s: %w", plg.Name, plgErrs))
+	if err := ValidateChannel(plg.Channel); err != nil {
+		errs = append(errs, err)
+	}
+
+	if plg.Version == "" {
+		errs = append(errs, fmt.Errorf("version not specified"))
+	} else {
+		_, err := version.NewSemver(plg.Version)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid semver version: %w", err))
 		}
 	}
 
-	return errs.ErrorOrNil()
+	return errs, hasDigest
+}
+
+func validateDownload(d structs.Download) []error {
+	var errs []error
+
+	if d.OS == "" {
+		errs = append(errs, fmt.Errorf("os must be specified"))
+	}
+
+	if d.Arch == "" {
+		errs = append(errs, fmt.Errorf("arch must be specified"))
+	}
+
+	if d.URL == "" {
+		errs = append(errs, fmt.Errorf("no download URL defined"))
+	}
+
+	switch d.Libc {
+	case "", LibcGlibc, LibcMusl:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported libc %q", d.Libc))
+	}
+
+	if err := installer.ValidateArchiveFormat(d.Format); err != nil {
+		errs = append(errs, err)
+	}
+
+	if d.ArchiveFile != "" {
+		if err := installer.ValidateArchiveFile(d.ArchiveFile); err != nil {
+			errs = append(errs, fmt.Errorf("archive_file: %w", err))
+		}
+	}
+
+	if d.MinOSVersion != "" {
+		if _, err := version.NewVersion(d.MinOSVersion); err != nil {
+			errs = append(errs, fmt.Errorf("invalid min_os_version: %w", err))
+		}
+	}
+
+	if d.SHA256 != "" {
+		if err := validateDigest(d.SHA256, sha256.Size); err != nil {
+			errs = append(errs, fmt.Errorf("sha256: %w", err))
+		}
+	}
+
+	if d.SHA512 != "" {
+		if err := validateDigest(d.SHA512, sha512.Size); err != nil {
+			errs = append(errs, fmt.Errorf("sha512: %w", err))
+		}
+	}
+
+	return errs
+}
+
+// definesDownloads reports whether plg or any of its releases define
+// download blocks.
+func definesDownloads(plg structs.PluginDesc) bool {
+	if len(plg.Downloads) > 0 {
+		return true
+	}
+
+	for _, release := range plg.Releases {
+		if len(release.Downloads) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// definesArchiveFormat reports whether plg, any of its releases or downloads
+// define an archive format.
+func definesArchiveFormat(plg structs.PluginDesc) bool {
+	if plg.ArchiveFormat != "" {
+		return true
+	}
+
+	for _, d := range plg.Downloads {
+		if d.Format != "" {
+			return true
+		}
+	}
+
+	for _, release := range plg.Releases {
+		if release.ArchiveFormat != "" {
+			return true
+		}
+
+		for _, d := range release.Downloads {
+			if d.Format != "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func validateDigest(digest string, size int) error {
+	blob, err := hex.DecodeString(digest)
+	if err != nil {
+		return fmt.Errorf("invalid hex encoding: %w", err)
+	}
+
+	if len(blob) != size {
+		return fmt.Errorf("invalid digest length %d, expected %d", len(blob), size)
+	}
+
+	return nil
 }
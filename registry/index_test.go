@@ -0,0 +1,162 @@
+package registry
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+func TestResolveRelativeURLs(t *testing.T) {
+	cwd, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwdURL := "file://" + filepath.ToSlash(cwd)
+
+	cases := []struct {
+		name     string
+		indexURL string
+		url      string
+		expected string
+	}{
+		{
+			name:     "current directory",
+			indexURL: "https://example.com/repo/index.json",
+			url:      "./artifacts/plugin.tar.gz",
+			expected: "https://example.com/repo/artifacts/plugin.tar.gz",
+		},
+		{
+			name:     "parent directory",
+			indexURL: "https://example.com/repo/index.json",
+			url:      "../artifacts/plugin.tar.gz",
+			expected: "https://example.com/artifacts/plugin.tar.gz",
+		},
+		{
+			name:     "parent of root",
+			indexURL: "https://example.com/index.json",
+			url:      "../../plugin.tar.gz",
+			expected: "https://example.com/plugin.tar.gz",
+		},
+		{
+			name:     "index query",
+			indexURL: "https://example.com/repo/index.json?token=secret#index",
+			url:      "./plugin.tar.gz",
+			expected: "https://example.com/repo/plugin.tar.gz",
+		},
+		{
+			name:     "template",
+			indexURL: "https://example.com/repo/index.json",
+			url:      "./{{version}}/{{plugin_name}}_{{os}}_{{arch}}.{{ext}}",
+			expected: "https://example.com/repo/{{version}}/{{plugin_name}}_{{os}}_{{arch}}.{{ext}}",
+		},
+		{
+			name:     "absolute URL",
+			indexURL: "https://example.com/repo/index.json",
+			url:      "https://cdn.example.com/plugin.tar.gz",
+			expected: "https://cdn.example.com/plugin.tar.gz",
+		},
+		{
+			name:     "go-getter shorthand",
+			indexURL: "https://example.com/repo/index.json",
+			url:      "github.com/example/plugin",
+			expected: "github.com/example/plugin",
+		},
+		{
+			name:     "implicit relative path",
+			indexURL: "https://example.com/repo/index.json",
+			url:      "artifacts/plugin.tar.gz",
+			expected: "artifacts/plugin.tar.gz",
+		},
+		{
+			name:     "file URL",
+			indexURL: "file:///srv/mirror/index.json",
+			url:      "./artifacts/plugin.tar.gz",
+			expected: "file:///srv/mirror/artifacts/plugin.tar.gz",
+		},
+		{
+			name:     "file URL parent directory",
+			indexURL: "file:///srv/mirror/index.json",
+			url:      "../plugin.tar.gz",
+			expected: "file:///srv/plugin.tar.gz",
+		},
+		{
+			name:     "local path",
+			indexURL: "mirror/index.json",
+			url:      "./artifacts/plugin.tar.gz",
+			expected: cwdURL + "/mirror/artifacts/plugin.tar.gz",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			index := &structs.RepositoryIndex{
+				Plugins: []structs.PluginDesc{
+					{
+						Name:             "test",
+						ArtifactTemplate: c.url,
+						Checksums:        c.url,
+						Artifacts:        []structs.Artifact{{OS: "linux", AMD64: c.url, ARM: c.url, ARM64: c.url, I386: c.url}},
+						Downloads:        []structs.Download{{OS: "linux", Arch: "amd64", URL: c.url}},
+						Releases: []structs.Release{
+							{
+								Version:          "1.0.0",
+								ArtifactTemplate: c.url,
+								Checksums:        c.url,
+								Artifacts:        []structs.Artifact{{OS: "linux", AMD64: c.url}},
+								Downloads:        []structs.Download{{OS: "linux", Arch: "amd64", URL: c.url}},
+							},
+						},
+					},
+				},
+			}
+
+			if err := ResolveRelativeURLs(c.indexURL, index); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			plg := index.Plugins[0]
+			release := plg.Releases[0]
+
+			for name, value := range map[string]string{
+				"artifact_template":         plg.ArtifactTemplate,
+				"checksums":                 plg.Checksums,
+				"artifact amd64":            plg.Artifacts[0].AMD64,
+				"artifact arm":              plg.Artifacts[0].ARM,
+				"artifact arm64":            plg.Artifacts[0].ARM64,
+				"artifact i386":             plg.Artifacts[0].I386,
+				"download":                  plg.Downloads[0].URL,
+				"release artifact_template": release.ArtifactTemplate,
+				"release checksums":         release.Checksums,
+				"release artifact":          release.Artifacts[0].AMD64,
+				"release download":          release.Downloads[0].URL,
+			} {
+				if value != c.expected {
+					t.Errorf("%s: expected %q but got %q", name, c.expected, value)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeIndexIgnoresRepository(t *testing.T) {
+	cases := map[string]string{
+		"index.json": `{"meta": {"version": "v1.0.0"}, "plugins": [{"name": "test", "source": "", "repository": "other"}]}`,
+		"index.yaml": "meta:\n  version: v1.0.0\nplugins:\n  - name: test\n    repository: other\n",
+		"index.hcl":  "meta {\n  version = \"v1.0.0\"\n}\n\nplugin \"test\" {\n  source = \"\"\n  pluginTypes = []\n  repository = \"other\"\n}\n",
+	}
+
+	for path, content := range cases {
+		t.Run(path, func(t *testing.T) {
+			index, err := DecodeIndex(path, strings.NewReader(content))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(index.Plugins) != 1 || index.Plugins[0].Repository != "" {
+				t.Errorf("expected the repository to be ignored but got %+v", index.Plugins)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+// SortKey defines the order of plugin listings.
+type SortKey string
+
+// Supported sort keys.
+const (
+	SortByName       SortKey = "name"
+	SortByVersion    SortKey = "version"
+	SortByRepository SortKey = "repository"
+	SortByPriority   SortKey = "priority"
+)
+
+// ListOptions configures sorting and pagination of plugin listings. The
+// zero value returns all plugins sorted by name.
+type ListOptions struct {
+	// SortBy defines the sort order. Defaults to SortByName. Ties are
+	// always broken by name so listings are deterministic.
+	SortBy SortKey
+
+	// Descending reverses the sort order.
+	Descending bool
+
+	// Offset is the number of plugins to skip.
+	Offset int
+
+	// Limit is the maximum number of plugins to return. Zero
+	// means no limit.
+	Limit int
+}
+
+// ParseSortKey parses key into a SortKey. An empty key selects
+// SortByName.
+func ParseSortKey(key string) (SortKey, error) {
+	switch SortKey(strings.ToLower(key)) {
+	case "", SortByName:
+		return SortByName, nil
+	case SortByVersion:
+		return SortByVersion, nil
+	case SortByRepository:
+		return SortByRepository, nil
+	case SortByPriority:
+		return SortByPriority, nil
+	default:
+		return "", fmt.Errorf("unsupported sort key %q", key)
+	}
+}
+
+// Paginate returns the page of items selected by the Offset and Limit of
+// opts.
+func Paginate[T any](items []T, opts ListOptions) []T {
+	if opts.Offset > 0 {
+		if opts.Offset >= len(items) {
+			return items[:0]
+		}
+
+		items = items[opts.Offset:]
+	}
+
+	if opts.Limit > 0 && opts.Limit < len(items) {
+		items = items[:opts.Limit]
+	}
+
+	return items
+}
+
+// sortPlugins sorts list in the order defined by opts. Callers must hold
+// at least a read lock on reg.
+func (reg *Registry) sortPlugins(list []structs.PluginDesc, opts ListOptions) {
+	sort.SliceStable(list, func(i, j int) bool {
+		cmp := reg.comparePlugins(list[i], list[j], opts.SortBy)
+		if opts.Descending {
+			return cmp > 0
+		}
+
+		return cmp < 0
+	})
+}
+
+// comparePlugins compares a and b by key and falls back to comparing
+// by name. Callers must hold at least a read lock on reg.
+func (reg *Registry) comparePlugins(a, b structs.PluginDesc, key SortKey) int {
+	var cmp int
+
+	switch key {
+	case SortByVersion:
+		cmp = compareVersions(a.Version, b.Version)
+	case SortByRepository:
+		cmp = strings.Compare(a.Repository, b.Repository)
+	case SortByPriority:
+		cmp = reg.repos[a.Repository].Priority - reg.repos[b.Repository].Priority
+	}
+
+	if cmp != 0 {
+		return cmp
+	}
+
+	return strings.Compare(a.Name, b.Name)
+}
+
+// compareVersions compares two semantic versions. Invalid versions are
+// sorted before valid ones.
+func compareVersions(a, b string) int {
+	va, errA := version.NewSemver(a)
+	vb, errB := version.NewSemver(b)
+
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	default:
+		return va.Compare(vb)
+	}
+}
@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []int{0, 1, 2, 3, 4}
+
+	cases := []struct {
+		name     string
+		offset   int
+		limit    int
+		expected []int
+	}{
+		{name: "all", expected: items},
+		{name: "first page", limit: 2, expected: []int{0, 1}},
+		{name: "second page", offset: 2, limit: 2, expected: []int{2, 3}},
+		{name: "last page", offset: 4, limit: 2, expected: []int{4}},
+		{name: "offset only", offset: 3, expected: []int{3, 4}},
+		{name: "limit exceeds items", limit: 10, expected: items},
+		{name: "offset at the end", offset: 5, expected: []int{}},
+		{name: "offset past the end", offset: 10, limit: 2, expected: []int{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			page := Paginate(items, ListOptions{Offset: c.offset, Limit: c.limit})
+
+			if !reflect.DeepEqual(page, c.expected) {
+				t.Errorf("expected %v but got %v", c.expected, page)
+			}
+		})
+	}
+
+	if page := Paginate([]int(nil), ListOptions{Offset: 1, Limit: 1}); len(page) != 0 {
+		t.Errorf("expected an empty page but got %v", page)
+	}
+}
+
+func TestParseSortKey(t *testing.T) {
+	cases := []struct {
+		key      string
+		expected SortKey
+		invalid  bool
+	}{
+		{key: "", expected: SortByName},
+		{key: "name", expected: SortByName},
+		{key: "Version", expected: SortByVersion},
+		{key: "repository", expected: SortByRepository},
+		{key: "PRIORITY", expected: SortByPriority},
+		{key: "downloads", invalid: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			key, err := ParseSortKey(c.key)
+
+			if c.invalid {
+				if err == nil {
+					t.Errorf("expected an error but got %q", key)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if key != c.expected {
+				t.Errorf("expected %q but got %q", c.expected, key)
+			}
+		})
+	}
+}
+
+func TestListPlugins(t *testing.T) {
+	reg := newTestRegistry(t,
+		map[string]*structs.RepositoryIndex{
+			"a-repo": testIndex(
+				testPlugin("charlie", releases("1.10.0")...),
+				testPlugin("bravo", releases("1.9.0")...),
+			),
+			"b-repo": testIndex(
+				testPlugin("delta", releases("1.2.0")...),
+				testPlugin("alpha", releases("1.2.0")...),
+			),
+		},
+		structs.Repository{Name: "a-repo", Priority: 2},
+		structs.Repository{Name: "b-repo", Priority: 1},
+	)
+
+	cases := []struct {
+		name     string
+		opts     ListOptions
+		expected []string
+	}{
+		{name: "default", expected: []string{"alpha", "bravo", "charlie", "delta"}},
+		{name: "name descending", opts: ListOptions{SortBy: SortByName, Descending: true}, expected: []string{"delta", "charlie", "bravo", "alpha"}},
+		{name: "version", opts: ListOptions{SortBy: SortByVersion}, expected: []string{"alpha", "delta", "bravo", "charlie"}},
+		{name: "version descending", opts: ListOptions{SortBy: SortByVersion, Descending: true}, expected: []string{"charlie", "bravo", "delta", "alpha"}},
+		{name: "repository", opts: ListOptions{SortBy: SortByRepository}, expected: []string{"bravo", "charlie", "alpha", "delta"}},
+		{name: "priority", opts: ListOptions{SortBy: SortByPriority}, expected: []string{"alpha", "delta", "bravo", "charlie"}},
+		{name: "page", opts: ListOptions{SortBy: SortByVersion, Offset: 1, Limit: 2}, expected: []string{"delta", "bravo"}},
+		{name: "page past the end", opts: ListOptions{Offset: 4, Limit: 2}, expected: []string{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			names := []string{}
+			for _, plg := range reg.ListPlugins(c.opts) {
+				names = append(names, plg.Name)
+			}
+
+			if !reflect.DeepEqual(names, c.expected) {
+				t.Errorf("expected %v but got %v", c.expected, names)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{a: "1.2.0", b: "1.10.0", expected: -1},
+		{a: "v1.0.0", b: "1.0.0", expected: 0},
+		{a: "1.0.0-beta.1", b: "1.0.0", expected: -1},
+		{a: "invalid", b: "0.0.1", expected: -1},
+		{a: "0.0.1", b: "invalid", expected: 1},
+		{a: "a", b: "b", expected: -1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.a+" "+c.b, func(t *testing.T) {
+			if cmp := compareVersions(c.a, c.b); cmp != c.expected {
+				t.Errorf("expected %d but got %d", c.expected, cmp)
+			}
+		})
+	}
+}
@@ -4,17 +4,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/hashicorp/go-getter/v2"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/go-version"
 	"github.com/ppacher/portmaster-plugin-registry/structs"
 	"github.com/safing/portmaster/plugin/shared"
 )
 
+// FetchTimeout is the maximum time allowed to fetch the index of a single
+// repository.
+var FetchTimeout = 2 * time.Minute
+
 // Common errors returned by the registry package.
 var (
 	ErrRepoDefined   = errors.New("repository is already defined")
@@ -27,24 +32,97 @@ type (
 	//
 	// It also supports detecting available plugin updates.
 	Registry struct {
-		l sync.RWMutex
+		l         sync.RWMutex
+		fetchLock sync.Mutex
+
+		repos map[string]structs.Repository
+
+		// indexes holds the last index successfully fetched for
+		// each repository.
+		indexes map[string]*structs.RepositoryIndex
+
+		// cache persists the last known-good index files on disk.
+		// It's nil if caching is disabled.
+		cache *indexCache
+
+		// plugins holds all available releases of a plugin from the
+		// highest-priority repository that provides it sorted by version,
+		// newest first.
+		plugins map[string][]structs.PluginDesc
+
+		// alternatives holds the releases of a plugin for each repository
+		// that provides it, including plugins shadowed by a higher-priority
+		// repository. It's indexed by plugin and repository name.
+		alternatives map[string]map[string][]structs.PluginDesc
+
+		// loggedWarnings holds the index validation warnings already
+		// logged for each repository. It's guarded by fetchLock.
+		loggedWarnings map[string]map[string]struct{}
+	}
+
+	// Alternative describes a repository that provides a plugin.
+	Alternative struct {
+		// Repository is the name of the repository.
+		Repository string `json:"repository"`
+
+		// Priority is the priority of the repository.
+		Priority int `json:"priority"`
 
-		repos   map[string]structs.Repository
-		plugins map[string]structs.PluginDesc
+		// Version is the latest version of the plugin that is part of the
+		// release channel of the repository.
+		Version string `json:"version"`
+
+		// Versions holds all versions provided by the repository sorted by
+		// version, newest first.
+		Versions []string `json:"versions"`
+
+		// Shadowed is true if the plugin is shadowed by a higher-priority
+		// repository.
+		Shadowed bool `json:"shadowed"`
 	}
 
 	// repoList is a helper to sort repositories by priority.
 	repoList []structs.Repository
+
+	// RepositoryError is returned as part of the *multierror.Error from
+	// Fetch for each repository that failed.
+	RepositoryError struct {
+		Repository string
+		Err        error
+	}
 )
 
+func (repoErr *RepositoryError) Error() string {
+	return fmt.Sprintf("repository %s: %s", repoErr.Repository, repoErr.Err)
+}
+
+func (repoErr *RepositoryError) Unwrap() error {
+	return repoErr.Err
+}
+
 // NewRegistry creates a new plugin registry. Note that the registry
 // does not yet contain any plugin repositories, users should call
 // AddRepository() and finally update the registry by calling Fetch().
-func NewRegistry() *Registry {
-	return &Registry{
-		repos:   make(map[string]structs.Repository),
-		plugins: make(map[string]structs.PluginDesc),
+//
+// If cacheDir is set, the last known-good index of each repository is stored
+// there and used if a repository cannot be reached. Also, conditional
+// requests are used when fetching indexes via HTTP. If cacheDir is empty
+// caching is disabled.
+func NewRegistry(cacheDir string) *Registry {
+	reg := &Registry{
+		repos:        make(map[string]structs.Repository),
+		indexes:      make(map[string]*structs.RepositoryIndex),
+		plugins:      make(map[string][]structs.PluginDesc),
+		alternatives: make(map[string]map[string][]structs.PluginDesc),
 	}
+
+	if cacheDir != "" {
+		reg.cache = &indexCache{
+			dir: cacheDir,
+		}
+	}
+
+	return reg
 }
 
 // AddRepository adds a new repository to the registry.
@@ -56,42 +134,131 @@ func (reg *Registry) AddRepository(repo structs.Repository) error {
 		return ErrRepoDefined
 	}
 
+	if err := ValidateChannel(repo.Channel); err != nil {
+		return err
+	}
+
 	reg.repos[repo.Name] = repo
 
 	return nil
 }
 
-// ListPlugins returns a list of available plugins.
-func (reg *Registry) ListPlugins() []structs.PluginDesc {
+// Repositories returns all repositories of the registry sorted by
+// priority.
+func (reg *Registry) Repositories() []structs.Repository {
 	reg.l.RLock()
 	defer reg.l.RUnlock()
 
-	list := make([]structs.PluginDesc, 0, len(reg.plugins))
-	for _, plg := range reg.plugins {
-		list = append(list, plg)
+	list := make(repoList, 0, len(reg.repos))
+	for _, repo := range reg.repos {
+		list = append(list, repo)
 	}
 
+	sort.Sort(list)
+
 	return list
 }
 
-// ByName returns the plugin by name.
+// ListPlugins returns a list of available plugins sorted and paginated as
+// defined by opts. Only the latest release of each plugin is returned, see
+// ByName.
+func (reg *Registry) ListPlugins(opts ListOptions) []structs.PluginDesc {
+	reg.l.RLock()
+	defer reg.l.RUnlock()
+
+	list := make([]structs.PluginDesc, 0, len(reg.plugins))
+	for _, releases := range reg.plugins {
+		list = append(list, reg.latest(releases))
+	}
+
+	reg.sortPlugins(list, opts)
+
+	return Paginate(list, opts)
+}
+
+// SplitPluginName splits a plugin name in the form of repo/plugin into the
+// repository and the plugin name. If name does not specify a repository,
+// repo is empty.
+func SplitPluginName(name string) (repo, plugin string) {
+	if idx := strings.Index(name, "/"); idx >= 0 {
+		return name[:idx], name[idx+1:]
+	}
+
+	return "", name
+}
+
+// ByName returns the latest release of the plugin by name that is
+// part of the release channel of the plugin repository. If there are
+// no releases in that channel the latest release is returned.
+//
+// Use repo/plugin to lookup a plugin from a specific repository, otherwise
+// the plugin from the highest-priority repository is returned.
 func (reg *Registry) ByName(name string) (structs.PluginDesc, bool) {
 	reg.l.RLock()
 	defer reg.l.RUnlock()
 
-	plg, ok := reg.plugins[name]
+	releases, ok := reg.releases(name)
+	if !ok {
+		return structs.PluginDesc{}, false
+	}
+
+	return reg.latest(releases), true
+}
+
+// Versions returns all available versions of the plugin name sorted by
+// version, newest first. name may be in the form of repo/plugin, see ByName.
+func (reg *Registry) Versions(name string) []string {
+	reg.l.RLock()
+	defer reg.l.RUnlock()
+
+	releases, _ := reg.releases(name)
+
+	versions := make([]string, len(releases))
+	for idx, release := range releases {
+		versions[idx] = release.Version
+	}
 
-	return plg, ok
+	return versions
 }
 
-// SearchByTag returns a list of plugins that contain searchTag in their tag list.
-func (reg *Registry) SearchByTag(searchTag string) []structs.PluginDesc {
+// ByNameVersion returns the latest release of the plugin name that matches
+// the github.com/hashicorp/go-version constraint. If no release matches the
+// constraint false is returned. An error is only returned if the constraint
+// cannot be parsed. name may be in the form of repo/plugin, see ByName.
+func (reg *Registry) ByNameVersion(name string, constraint string) (structs.PluginDesc, bool, error) {
+	constraints, err := version.NewConstraint(constraint)
+	if err != nil {
+		return structs.PluginDesc{}, false, fmt.Errorf("invalid version constraint %q: %w", constraint, err)
+	}
+
+	reg.l.RLock()
+	defer reg.l.RUnlock()
+
+	releases, _ := reg.releases(name)
+	for _, release := range releases {
+		v, err := version.NewSemver(release.Version)
+		if err != nil {
+			continue
+		}
+
+		if constraints.Check(v) {
+			return release, true, nil
+		}
+	}
+
+	return structs.PluginDesc{}, false, nil
+}
+
+// SearchByTag returns a list of plugins that contain searchTag in their tag list
+// sorted and paginated as defined by opts.
+func (reg *Registry) SearchByTag(searchTag string, opts ListOptions) []structs.PluginDesc {
 	reg.l.RLock()
 	defer reg.l.RUnlock()
 
 	var list []structs.PluginDesc
 L:
-	for _, plg := range reg.plugins {
+	for _, releases := range reg.plugins {
+		plg := reg.latest(releases)
 		for _, tag := range plg.Tags {
 			if tag == searchTag {
 				list = append(list, plg)
@@ -101,17 +268,21 @@ L:
 		}
 	}
 
-	return list
+	reg.sortPlugins(list, opts)
+
+	return Paginate(list, opts)
 }
 
-// SearchByType returns a list of plugins that implement type.
-func (reg *Registry) SearchByType(pType shared.PluginType) []structs.PluginDesc {
+// SearchByType returns a list of plugins that implement type sorted and
+// paginated as defined by opts.
+func (reg *Registry) SearchByType(pType shared.PluginType, opts ListOptions) []structs.PluginDesc {
 	reg.l.RLock()
 	defer reg.l.RUnlock()
 
 	var list []structs.PluginDesc
 L:
-	for _, plg := range reg.plugins {
+	for _, releases := range reg.plugins {
+		plg := reg.latest(releases)
 		for _, plgType := range plg.PluginTypes {
 			if plgType == pType {
 				list = append(list, plg)
@@ -121,125 +292,311 @@ L:
 		}
 	}
 
-	return list
+	reg.sortPlugins(list, opts)
+
+	return Paginate(list, opts)
 }
 
-// SearchByName returns a list of plugins that match name.
-func (reg *Registry) SearchByName(name string) []structs.PluginDesc {
+// SearchByName returns a list of plugins that match name sorted and
+// paginated as defined by opts.
+func (reg *Registry) SearchByName(name string, opts ListOptions) []structs.PluginDesc {
 	reg.l.RLock()
 	defer reg.l.RUnlock()
 
 	lowerName := strings.ToLower(name)
 
 	var list []structs.PluginDesc
-	for _, plg := range reg.plugins {
+	for _, releases := range reg.plugins {
+		plg := reg.latest(releases)
 		if strings.Contains(strings.ToLower(plg.Name), lowerName) {
 			list = append(list, plg)
 		}
 	}
 
-	return list
+	reg.sortPlugins(list, opts)
+
+	return Paginate(list, opts)
+}
+
+// logWarnings logs all index validation warnings of repo that have not been
+// logged before so they are not repeated on every refresh. Callers must hold
+// fetchLock.
+func (reg *Registry) logWarnings(repo string, warnings []string) {
+	if reg.loggedWarnings == nil {
+		reg.loggedWarnings = make(map[string]map[string]struct{})
+	}
+
+	logged := reg.loggedWarnings[repo]
+	if logged == nil {
+		logged = make(map[string]struct{})
+		reg.loggedWarnings[repo] = logged
+	}
+
+	for _, warning := range warnings {
+		if _, ok := logged[warning]; ok {
+			continue
+		}
+		logged[warning] = struct{}{}
+
+		hclog.L().Warn(warning, "repository", repo)
+	}
 }
 
 // Fetch fetches the repository index files and update the local
 // list of available plugins.
-func (reg *Registry) Fetch() error {
-	reg.l.Lock()
-	defer reg.l.Unlock()
+//
+// All repositories are fetched concurrently, each one limited by
+// FetchTimeout. If a repository cannot be fetched the last index
+// successfully fetched from that repository is used instead, either from
+// memory or from the on-disk index cache. In that case,
+// a *multierror.Error is returned that contains one *RepositoryError per
+// failed repository but the plugins of all other repositories are still updated.
+//
+// Cancelling ctx aborts all in-flight downloads.
+func (reg *Registry) Fetch(ctx context.Context) error {
+	// make sure only one fetch is running at a time so we never replace
+	// the plugin list with older results.
+	reg.fetchLock.Lock()
+	defer reg.fetchLock.Unlock()
 
-	pluginList := make(map[string]structs.PluginDesc)
+	repoList := reg.Repositories()
 
-	repoList := make(repoList, 0, len(reg.repos))
-	for _, repo := range reg.repos {
-		repoList = append(repoList, repo)
+	// fetch all index files and parse them
+	type result struct {
+		index    *structs.RepositoryIndex
+		warnings []string
+		err      error
 	}
 
-	// sort the repository list by priority
-	sort.Sort(repoList)
+	results := make([]result, len(repoList))
 
-	// fetch all index files and parse them
-	for _, repo := range repoList {
-		index, err := fetchIndex(repo)
-		if err != nil {
-			return err
+	var wg sync.WaitGroup
+	for idx, repo := range repoList {
+		wg.Add(1)
+
+		go func(idx int, repo structs.Repository) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, FetchTimeout)
+			defer cancel()
+
+			index, warnings, err := reg.fetchIndex(ctx, repo)
+			results[idx] = result{index, warnings, err}
+		}(idx, repo)
+	}
+	wg.Wait()
+
+	errs := new(multierror.Error)
+	indexes := make(map[string]*structs.RepositoryIndex, len(repoList))
+	pluginList := make(map[string][]structs.PluginDesc)
+	alternatives := make(map[string]map[string][]structs.PluginDesc)
+
+	reg.l.RLock()
+	for idx, repo := range repoList {
+		index := results[idx].index
+		reg.logWarnings(repo.Name, results[idx].warnings)
+
+		if err := results[idx].err; err != nil {
+			errs.Errors = append(errs.Errors, &RepositoryError{Repository: repo.Name, Err: err})
+
+			// fallback to the last index we fetched successfully
+			index = reg.indexes[repo.Name]
+
+			if index == nil {
+				cachedIndex, warnings, err := reg.loadCachedIndex(repo)
+				if err != nil {
+					hclog.L().Error("failed to load cached index", "repository", repo.Name, "error", err)
+				}
+				reg.logWarnings(repo.Name, warnings)
+
+				index = cachedIndex
+			}
+
+			if index != nil {
+				hclog.L().Warn("using last known-good index", "repository", repo.Name)
+			}
 		}
 
+		if index == nil {
+			continue
+		}
+
+		indexes[repo.Name] = index
+
 		for _, plg := range index.Plugins {
-			if _, ok := pluginList[plg.Name]; ok {
-				// this plugin has already be defined by a higher-priority
-				// repository.
+			plg.Repository = repo.Name
+
+			releases, err := PluginReleases(plg)
+			if err != nil {
+				errs.Errors = append(errs.Errors, &RepositoryError{Repository: repo.Name, Err: err})
+
 				continue
 			}
 
-			plg.Repository = repo.Name
+			if alternatives[plg.Name] == nil {
+				alternatives[plg.Name] = make(map[string][]structs.PluginDesc)
+			}
+			alternatives[plg.Name][repo.Name] = releases
 
-			pluginList[plg.Name] = plg
+			// the plugin may already be defined by a higher-priority
+			// repository, in that case it's only available as an
+			// alternative.
+			if _, ok := pluginList[plg.Name]; !ok {
+				pluginList[plg.Name] = releases
+			}
 		}
 	}
+	reg.l.RUnlock()
 
+	reg.l.Lock()
+	reg.indexes = indexes
 	reg.plugins = pluginList
+	reg.alternatives = alternatives
+	reg.l.Unlock()
 
-	return nil
+	return errs.ErrorOrNil()
 }
 
-// UpdateAvailable checks if an update to plgName is available. It compares the version
-// of the loaded repositories with the current version and returns the available version
-// if it is higher than the current one.
+// UpdateAvailable checks if an update to the installed plugin plg is available.
+// It compares the versions of the loaded repositories with the installed version
+// and returns the latest available version if it is higher than the installed one.
+//
+// Only releases that are part of the release channel followed by plg, or the one
+// of the plugin repository if plg does not follow a dedicated channel, and more
+// stable channels are considered. If plg has an update policy set, only versions
+// matching the policy constraint are considered.
+//
+// If plg has been installed from a specific repository, only releases of that
+// repository are considered. If that repository has been removed from the
+// registry, the plugin is looked up in all repositories like ByName does.
 //
 // If no update is available and empty string and a nil error is returned.
 // If there is no such plugin available ErrUnknownPlugin is returned. In case any of
 // the version cannot be parsed an error is returned.
-func (reg *Registry) UpdateAvailable(plgName string, currentVersion string) (string, error) {
+func (reg *Registry) UpdateAvailable(plg structs.InstalledPlugin) (string, error) {
 	reg.l.RLock()
 	defer reg.l.RUnlock()
 
-	plg, ok := reg.plugins[plgName]
+	name := plg.Name
+	if _, ok := reg.repos[plg.Repository]; plg.Repository != "" && ok {
+		name = plg.Repository + "/" + plg.Name
+	}
+
+	releases, ok := reg.releases(name)
 	if !ok {
 		return "", ErrUnknownPlugin
 	}
 
-	currentSemVer, err := version.NewSemver(currentVersion)
+	currentSemVer, err := version.NewSemver(plg.Version)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse current version %q: %w", currentVersion, err)
+		return "", fmt.Errorf("failed to parse current version %q: %w", plg.Version, err)
 	}
 
-	availableSemVer, err := version.NewSemver(plg.Version)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse available version %q: %w", plg.Version, err)
+	var constraints version.Constraints
+	if plg.UpdatePolicy != "" {
+		constraints, err = version.NewConstraint(plg.UpdatePolicy)
+		if err != nil {
+			return "", fmt.Errorf("invalid update policy %q: %w", plg.UpdatePolicy, err)
+		}
+	}
+
+	channel := plg.FollowChannel
+	if channel == "" {
+		channel = reg.repos[releases[0].Repository].Channel
 	}
 
-	if availableSemVer.GreaterThan(currentSemVer) {
-		return plg.Version, nil
+	// releases are sorted by version, newest first so the first matching
+	// release is the one we're looking for.
+	for _, release := range releases {
+		if !inChannel(release, channel) {
+			continue
+		}
+
+		availableSemVer, err := version.NewSemver(release.Version)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse available version %q: %w", release.Version, err)
+		}
+
+		if constraints != nil && !constraints.Check(availableSemVer) {
+			continue
+		}
+
+		if availableSemVer.GreaterThan(currentSemVer) {
+			return release.Version, nil
+		}
+
+		return "", nil
 	}
 
 	return "", nil
 }
 
-func fetchIndex(repo structs.Repository) (*structs.RepositoryIndex, error) {
-	res, err := new(getter.Client).Get(context.Background(), &getter.Request{
-		Src: repo.URL,
-		Dst: os.TempDir(),
-	})
-	if err != nil {
-		return nil, err
-	}
+// Alternatives returns all repositories that provide the plugin name. The
+// repository used by default comes first, all others are sorted by priority.
+func (reg *Registry) Alternatives(name string) []Alternative {
+	reg.l.RLock()
+	defer reg.l.RUnlock()
 
-	f, err := os.Open(res.Dst)
-	if err != nil {
-		return nil, err
+	var list []Alternative
+	for repoName, releases := range reg.alternatives[name] {
+		versions := make([]string, len(releases))
+		for idx, release := range releases {
+			versions[idx] = release.Version
+		}
+
+		list = append(list, Alternative{
+			Repository: repoName,
+			Priority:   reg.repos[repoName].Priority,
+			Version:    reg.latest(releases).Version,
+			Versions:   versions,
+			Shadowed:   reg.plugins[name][0].Repository != repoName,
+		})
 	}
-	defer f.Close()
 
-	index, err := DecodeIndex(res.Dst, f)
-	if err != nil {
-		return nil, err
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Shadowed != list[j].Shadowed {
+			return !list[i].Shadowed
+		}
+
+		if list[i].Priority != list[j].Priority {
+			return list[i].Priority < list[j].Priority
+		}
+
+		return list[i].Repository < list[j].Repository
+	})
+
+	return list
+}
+
+// releases returns all releases of the plugin name. If name is in the form
+// of repo/plugin, only releases of that repository are returned. The caller
+// must hold reg.l.
+func (reg *Registry) releases(name string) ([]structs.PluginDesc, bool) {
+	repo, plugin := SplitPluginName(name)
+	if repo == "" {
+		releases, ok := reg.plugins[plugin]
+
+		return releases, ok
 	}
 
-	if err := ValidateIndex(index); err != nil {
-		return nil, err
+	releases, ok := reg.alternatives[plugin][repo]
+
+	return releases, ok
+}
+
+// latest returns the latest release that is part of the release channel of
+// the plugin repository. If there's no such release, the latest release is
+// returned. The caller must hold reg.l.
+func (reg *Registry) latest(releases []structs.PluginDesc) structs.PluginDesc {
+	channel := reg.repos[releases[0].Repository].Channel
+
+	for _, release := range releases {
+		if inChannel(release, channel) {
+			return release
+		}
 	}
 
-	return index, nil
+	return releases[0]
 }
 
 func (list repoList) Len() int           { return len(list) }
@@ -0,0 +1,328 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+// testPlugin returns a plugin that provides the given releases.
+func testPlugin(name string, releases ...structs.Release) structs.PluginDesc {
+	return structs.PluginDesc{
+		Name:             name,
+		SourceURL:        "https://example.com/" + name,
+		ArtifactTemplate: "https://example.com/{{plugin_name}}/{{version}}/{{os}}_{{arch}}.{{ext}}",
+		Releases:         releases,
+	}
+}
+
+// releases returns a release for each version.
+func releases(versions ...string) []structs.Release {
+	list := make([]structs.Release, len(versions))
+	for idx, v := range versions {
+		list[idx] = structs.Release{Version: v}
+	}
+
+	return list
+}
+
+// testIndex returns a repository index that contains plugins.
+func testIndex(plugins ...structs.PluginDesc) *structs.RepositoryIndex {
+	return &structs.RepositoryIndex{
+		Meta: structs.IndexMeta{
+			Version: IndexVersion11,
+		},
+		Plugins: plugins,
+	}
+}
+
+// serveIndexes starts a HTTP server that serves each index at /<name>.json.
+func serveIndexes(t *testing.T, indexes map[string]*structs.RepositoryIndex) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index, ok := indexes[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".json")]
+		if !ok {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(index)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// newTestRegistry creates a registry that contains repos and fetches their
+// indexes from indexes, keyed by repository name.
+func newTestRegistry(t *testing.T, indexes map[string]*structs.RepositoryIndex, repos ...structs.Repository) *Registry {
+	t.Helper()
+
+	srv := serveIndexes(t, indexes)
+
+	reg := NewRegistry("")
+	for _, repo := range repos {
+		repo.URL = srv.URL + "/" + repo.Name + ".json"
+
+		if err := reg.AddRepository(repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := reg.Fetch(context.Background()); err != nil {
+		t.Fatalf("failed to fetch indexes: %s", err)
+	}
+
+	return reg
+}
+
+// installed returns an installed plugin for name at version.
+func installed(name string, version string) structs.InstalledPlugin {
+	return structs.InstalledPlugin{
+		PluginDesc: structs.PluginDesc{
+			Name:    name,
+			Version: version,
+		},
+	}
+}
+
+func TestUpdateAvailablePolicies(t *testing.T) {
+	reg := newTestRegistry(t,
+		map[string]*structs.RepositoryIndex{
+			"main": testIndex(testPlugin("test", releases("1.0.0", "1.0.1", "1.1.0", "1.1.1", "1.2.0-beta.1", "2.0.0")...)),
+		},
+		structs.Repository{Name: "main"},
+	)
+
+	// the constraints match the ones created by the update policies of
+	// the manager package.
+	cases := []struct {
+		name     string
+		current  string
+		policy   string
+		expected string
+		invalid  bool
+	}{
+		{name: "no policy", current: "1.0.0", expected: "2.0.0"},
+		{name: "pin", current: "1.0.0", policy: "= 1.0.0"},
+		{name: "patch", current: "1.0.0", policy: "~> 1.0.0", expected: "1.0.1"},
+		{name: "patch up to date", current: "1.1.1", policy: "~> 1.1.1"},
+		{name: "minor", current: "1.0.0", policy: "~> 1.0", expected: "1.1.1"},
+		{name: "minor up to date", current: "1.1.1", policy: "~> 1.1"},
+		{name: "any", current: "1.0.0", expected: "2.0.0"},
+		{name: "any up to date", current: "2.0.0"},
+		{name: "newer than available", current: "3.0.0"},
+		{name: "invalid policy", current: "1.0.0", policy: "latest", invalid: true},
+		{name: "invalid version", current: "one", invalid: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			plg := installed("test", c.current)
+			plg.UpdatePolicy = c.policy
+
+			update, err := reg.UpdateAvailable(plg)
+
+			if c.invalid {
+				if err == nil {
+					t.Errorf("expected an error but got update %q", update)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if update != c.expected {
+				t.Errorf("expected update %q but got %q", c.expected, update)
+			}
+		})
+	}
+
+	if _, err := reg.UpdateAvailable(installed("other", "1.0.0")); !errors.Is(err, ErrUnknownPlugin) {
+		t.Errorf("expected ErrUnknownPlugin but got %v", err)
+	}
+}
+
+func TestUpdateAvailableChannels(t *testing.T) {
+	plg := testPlugin("test",
+		structs.Release{Version: "1.0.0"},
+		structs.Release{Version: "1.0.1", Channel: structs.ChannelBeta},
+		structs.Release{Version: "1.1.0-rc.1"},
+		structs.Release{Version: "1.2.0-dev.1", Channel: structs.ChannelNightly},
+	)
+
+	reg := newTestRegistry(t,
+		map[string]*structs.RepositoryIndex{
+			"stable":  testIndex(plg),
+			"beta":    testIndex(plg),
+			"nightly": testIndex(plg),
+		},
+		structs.Repository{Name: "stable", Priority: 0},
+		structs.Repository{Name: "beta", Priority: 1, Channel: structs.ChannelBeta},
+		structs.Repository{Name: "nightly", Priority: 2, Channel: structs.ChannelNightly},
+	)
+
+	cases := []struct {
+		name       string
+		repository string
+		current    string
+		follow     string
+		expected   string
+	}{
+		{name: "default repository", current: "0.9.0", expected: "1.0.0"},
+		{name: "stable repository", repository: "stable", current: "0.9.0", expected: "1.0.0"},
+		{name: "beta repository", repository: "beta", current: "0.9.0", expected: "1.1.0-rc.1"},
+		{name: "nightly repository", repository: "nightly", current: "0.9.0", expected: "1.2.0-dev.1"},
+		{name: "follow beta", repository: "stable", current: "1.0.0", follow: structs.ChannelBeta, expected: "1.1.0-rc.1"},
+		{name: "follow nightly", repository: "beta", current: "1.0.0", follow: structs.ChannelNightly, expected: "1.2.0-dev.1"},
+		{name: "follow stable", repository: "nightly", current: "0.9.0", follow: structs.ChannelStable, expected: "1.0.0"},
+		{name: "no downgrade to stable", repository: "beta", current: "1.1.0-rc.1", follow: structs.ChannelStable},
+		{name: "beta up to date", repository: "beta", current: "1.1.0-rc.1"},
+		{name: "unknown channel", repository: "nightly", current: "0.9.0", follow: "canary"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			plg := installed("test", c.current)
+			plg.Repository = c.repository
+			plg.FollowChannel = c.follow
+
+			update, err := reg.UpdateAvailable(plg)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if update != c.expected {
+				t.Errorf("expected update %q but got %q", c.expected, update)
+			}
+		})
+	}
+}
+
+func TestReleaseChannel(t *testing.T) {
+	cases := []struct {
+		version  string
+		channel  string
+		expected string
+	}{
+		{version: "1.0.0", expected: structs.ChannelStable},
+		{version: "v1.0.0", expected: structs.ChannelStable},
+		{version: "1.0.0-beta.1", expected: structs.ChannelBeta},
+		{version: "1.0.0-rc.1", expected: structs.ChannelBeta},
+		{version: "1.0.0", channel: structs.ChannelNightly, expected: structs.ChannelNightly},
+		{version: "1.0.0-beta.1", channel: structs.ChannelStable, expected: structs.ChannelStable},
+		{version: "invalid", expected: structs.ChannelStable},
+	}
+
+	for _, c := range cases {
+		t.Run(c.version+" "+c.channel, func(t *testing.T) {
+			channel := ReleaseChannel(structs.PluginDesc{Version: c.version, Channel: c.channel})
+			if channel != c.expected {
+				t.Errorf("expected channel %q but got %q", c.expected, channel)
+			}
+		})
+	}
+}
+
+func TestInChannel(t *testing.T) {
+	cases := []struct {
+		channel  string
+		selected string
+		expected bool
+	}{
+		{channel: structs.ChannelStable, selected: "", expected: true},
+		{channel: structs.ChannelBeta, selected: ""},
+		{channel: structs.ChannelStable, selected: structs.ChannelStable, expected: true},
+		{channel: structs.ChannelBeta, selected: structs.ChannelStable},
+		{channel: structs.ChannelNightly, selected: structs.ChannelStable},
+		{channel: structs.ChannelStable, selected: structs.ChannelBeta, expected: true},
+		{channel: structs.ChannelBeta, selected: structs.ChannelBeta, expected: true},
+		{channel: structs.ChannelNightly, selected: structs.ChannelBeta},
+		{channel: structs.ChannelStable, selected: structs.ChannelNightly, expected: true},
+		{channel: structs.ChannelBeta, selected: structs.ChannelNightly, expected: true},
+		{channel: structs.ChannelNightly, selected: structs.ChannelNightly, expected: true},
+		{channel: structs.ChannelStable, selected: "canary"},
+		{channel: "canary", selected: structs.ChannelNightly},
+	}
+
+	for _, c := range cases {
+		t.Run(c.channel+" in "+c.selected, func(t *testing.T) {
+			plg := structs.PluginDesc{Version: "1.0.0", Channel: c.channel}
+
+			if inChannel(plg, c.selected) != c.expected {
+				t.Errorf("expected %t", c.expected)
+			}
+		})
+	}
+}
+
+func TestAddRepositoryValidatesChannel(t *testing.T) {
+	reg := NewRegistry("")
+
+	for _, channel := range []string{"", structs.ChannelStable, structs.ChannelBeta, structs.ChannelNightly} {
+		if err := reg.AddRepository(structs.Repository{Name: "repo-" + channel, Channel: channel}); err != nil {
+			t.Errorf("channel %q: unexpected error: %s", channel, err)
+		}
+	}
+
+	if err := reg.AddRepository(structs.Repository{Name: "canary", Channel: "canary"}); err == nil {
+		t.Errorf("expected unsupported channel to be rejected")
+	}
+}
+
+func TestUpdateAvailableRepository(t *testing.T) {
+	spoofed := testPlugin("test", releases("1.0.0", "2.0.0")...)
+	spoofed.Repository = "mirror"
+
+	reg := newTestRegistry(t,
+		map[string]*structs.RepositoryIndex{
+			"main":   testIndex(spoofed),
+			"mirror": testIndex(testPlugin("test", releases("1.0.0", "3.0.0")...)),
+		},
+		structs.Repository{Name: "main", Priority: 0},
+		structs.Repository{Name: "mirror", Priority: 1},
+	)
+
+	// the repository is always set by the registry.
+	if plg, _ := reg.ByName("test"); plg.Repository != "main" {
+		t.Errorf("expected repository main but got %q", plg.Repository)
+	}
+
+	cases := []struct {
+		repository string
+		expected   string
+	}{
+		{repository: "", expected: "2.0.0"},
+		{repository: "main", expected: "2.0.0"},
+		{repository: "mirror", expected: "3.0.0"},
+		{repository: "removed", expected: "2.0.0"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.repository, func(t *testing.T) {
+			plg := installed("test", "1.0.0")
+			plg.Repository = c.repository
+
+			update, err := reg.UpdateAvailable(plg)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if update != c.expected {
+				t.Errorf("expected update %q but got %q", c.expected, update)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+)
+
+// ErrInvalidQuery is returned if a search query cannot be parsed.
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// Score weights of search matches per field and kind of match.
+const (
+	scoreNameExact    = 20
+	scoreNamePrefix   = 10
+	scoreNameContains = 6
+	scoreNameFuzzy    = 4
+	scoreTypeExact    = 5
+	scoreTagExact     = 5
+	scoreTagContains  = 3
+	scoreTagFuzzy     = 2
+	scoreAuthor       = 3
+	scoreAuthorFuzzy  = 1
+	scoreDescription  = 2
+	scoreDescFuzzy    = 1
+)
+
+type (
+	// Query is a parsed search query. See ParseQuery for the
+	// query syntax.
+	Query struct {
+		// Terms holds all free-text search terms, lower-cased.
+		Terms []string
+
+		// Types, Tags and Licenses hold the values of the type:, tag:
+		// and license: facets. A plugin must match all facets.
+		Types    []string
+		Tags     []string
+		Licenses []string
+	}
+
+	// SearchResult is a plugin matching a search query.
+	SearchResult struct {
+		Plugin structs.PluginDesc `json:"plugin"`
+
+		// Score ranks the result, higher is better. Results that only
+		// match facets have a score of zero.
+		Score int `json:"score"`
+	}
+)
+
+// ParseQuery parses a search query. The query consists of whitespace
+// separated free-text terms and facets in the form of key:value. Supported
+// facets are type:, tag: and license:. For example:
+//
+//	dns type:resolver tag:privacy license:MIT
+func ParseQuery(query string) (Query, error) {
+	var q Query
+
+	for _, field := range strings.Fields(query) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			q.Terms = append(q.Terms, strings.ToLower(field))
+
+			continue
+		}
+
+		if value == "" {
+			return Query{}, fmt.Errorf("%w: missing value for facet %q", ErrInvalidQuery, key)
+		}
+
+		switch strings.ToLower(key) {
+		case "type":
+			q.Types = append(q.Types, value)
+		case "tag":
+			q.Tags = append(q.Tags, value)
+		case "license":
+			q.Licenses = append(q.Licenses, value)
+		default:
+			return Query{}, fmt.Errorf("%w: unsupported facet %q", ErrInvalidQuery, key)
+		}
+	}
+
+	return q, nil
+}
+
+// Search searches the latest release of all plugins for query and returns
+// all matches ranked by relevance. See ParseQuery for the query syntax.
+//
+// If opts.SortBy is set, results are sorted by that key instead of
+// relevance. Results are always paginated as defined by opts.
+func (reg *Registry) Search(query string, opts ListOptions) ([]SearchResult, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := SearchPlugins(reg.ListPlugins(ListOptions{}), q)
+
+	if opts.SortBy != "" {
+		reg.l.RLock()
+		sort.SliceStable(results, func(i, j int) bool {
+			cmp := reg.comparePlugins(results[i].Plugin, results[j].Plugin, opts.SortBy)
+			if opts.Descending {
+				return cmp > 0
+			}
+
+			return cmp < 0
+		})
+		reg.l.RUnlock()
+	} else if opts.Descending {
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+	}
+
+	return Paginate(results, opts), nil
+}
+
+// SearchPlugins returns all plugins that match q ranked by relevance.
+//
+// A plugin matches if it matches all facets of q and each free-text term
+// matches at least one of the plugin name, description, tags, author or plugin
+// types. Terms are matched exactly, by prefix, by substring and, to tolerate
+// typos, by edit distance.
+func SearchPlugins(plugins []structs.PluginDesc, q Query) []SearchResult {
+	var results []SearchResult
+
+	for _, plg := range plugins {
+		if !matchFacets(plg, q) {
+			continue
+		}
+
+		score, ok := scoreTerms(plg, q.Terms)
+		if !ok {
+			continue
+		}
+
+		results = append(results, SearchResult{
+			Plugin: plg,
+			Score:  score,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+
+		return results[i].Plugin.Name < results[j].Plugin.Name
+	})
+
+	return results
+}
+
+func matchFacets(plg structs.PluginDesc, q Query) bool {
+	for _, pType := range q.Types {
+		found := false
+		for _, plgType := range plg.PluginTypes {
+			if strings.EqualFold(string(plgType), pType) {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	for _, tag := range q.Tags {
+		found := false
+		for _, plgTag := range plg.Tags {
+			if strings.EqualFold(plgTag, tag) {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	for _, license := range q.Licenses {
+		if !strings.EqualFold(plg.License, license) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// scoreTerms returns the accumulated score of all terms for plg. It returns
+// false if any of the terms does not match plg.
+func scoreTerms(plg structs.PluginDesc, terms []string) (int, bool) {
+	total := 0
+
+	for _, term := range terms {
+		score := scoreTerm(plg, term)
+		if score == 0 {
+			return 0, false
+		}
+
+		total += score
+	}
+
+	return total, true
+}
+
+func scoreTerm(plg structs.PluginDesc, term string) int {
+	score := 0
+
+	name := strings.ToLower(plg.Name)
+	switch {
+	case name == term:
+		score += scoreNameExact
+	case strings.HasPrefix(name, term):
+		score += scoreNamePrefix
+	case strings.Contains(name, term):
+		score += scoreNameContains
+	case fuzzyMatch(name, term):
+		score += scoreNameFuzzy
+	}
+
+	for _, pType := range plg.PluginTypes {
+		if strings.EqualFold(string(pType), term) {
+			score += scoreTypeExact
+
+			break
+		}
+	}
+
+	tagScore := 0
+	for _, tag := range plg.Tags {
+		tag = strings.ToLower(tag)
+
+		s := 0
+		switch {
+		case tag == term:
+			s = scoreTagExact
+		case strings.Contains(tag, term):
+			s = scoreTagContains
+		case fuzzyMatch(tag, term):
+			s = scoreTagFuzzy
+		}
+
+		if s > tagScore {
+			tagScore = s
+		}
+	}
+	score += tagScore
+
+	author := strings.ToLower(plg.Author)
+	switch {
+	case strings.Contains(author, term):
+		score += scoreAuthor
+	case fuzzyMatch(author, term):
+		score += scoreAuthorFuzzy
+	}
+
+	description := strings.ToLower(plg.Description)
+	switch {
+	case strings.Contains(description, term):
+		score += scoreDescription
+	case fuzzyMatch(description, term):
+		score += scoreDescFuzzy
+	}
+
+	return score
+}
+
+// fuzzyMatch reports whether any word of text is within the allowed edit
+// distance of term.
+func fuzzyMatch(text, term string) bool {
+	maxDist := maxEditDistance(term)
+	if maxDist == 0 {
+		return false
+	}
+
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, word := range words {
+		if levenshtein(word, term) <= maxDist {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxEditDistance returns the number of typos tolerated for term.
+func maxEditDistance(term string) int {
+	switch n := len([]rune(term)); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
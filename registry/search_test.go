@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ppacher/portmaster-plugin-registry/structs"
+	"github.com/safing/portmaster/plugin/shared"
+)
+
+func TestParseQuery(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected Query
+		invalid  bool
+	}{
+		{query: ""},
+		{query: "  DNS   Resolver ", expected: Query{Terms: []string{"dns", "resolver"}}},
+		{
+			query: "dns type:resolver tag:privacy license:MIT",
+			expected: Query{
+				Terms:    []string{"dns"},
+				Types:    []string{"resolver"},
+				Tags:     []string{"privacy"},
+				Licenses: []string{"MIT"},
+			},
+		},
+		{query: "TAG:privacy Tag:ads", expected: Query{Tags: []string{"privacy", "ads"}}},
+		{query: "tag:", invalid: true},
+		{query: "author:alice", invalid: true},
+		{query: ":dns", invalid: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			q, err := ParseQuery(c.query)
+
+			if c.invalid {
+				if !errors.Is(err, ErrInvalidQuery) {
+					t.Errorf("expected ErrInvalidQuery but got %+v (err=%v)", q, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(q, c.expected) {
+				t.Errorf("expected %+v but got %+v", c.expected, q)
+			}
+		})
+	}
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	cases := []struct {
+		text     string
+		term     string
+		expected bool
+	}{
+		// terms up to three characters must match exactly.
+		{text: "dns", term: "dnz"},
+		{text: "ads", term: "ad"},
+
+		// one typo for terms up to six characters.
+		{text: "block", term: "blick", expected: true},
+		{text: "privacy filter", term: "filtr", expected: true},
+		{text: "block", term: "blokc"},
+
+		// two typos for longer terms.
+		{text: "resolver", term: "resovler", expected: true},
+		{text: "dns-resolver", term: "resolvr", expected: true},
+		{text: "resolver", term: "rsvlr"},
+		{text: "blocklist", term: "bluckliiz"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.text+" "+c.term, func(t *testing.T) {
+			if fuzzyMatch(c.text, c.term) != c.expected {
+				t.Errorf("expected %t", c.expected)
+			}
+		})
+	}
+}
+
+func TestSearchPlugins(t *testing.T) {
+	plugins := []structs.PluginDesc{
+		{
+			Name:        "dns-resolver",
+			PluginTypes: []shared.PluginType{shared.PluginTypeResolver},
+			Tags:        []string{"dns", "privacy"},
+			License:     "MIT",
+			Author:      "alice",
+			Description: "Resolves DNS queries over HTTPS",
+		},
+		{
+			Name:        "blocklist",
+			PluginTypes: []shared.PluginType{shared.PluginTypeDecider},
+			Tags:        []string{"privacy", "ads"},
+			License:     "GPL-3.0",
+			Author:      "bob",
+			Description: "Blocks ads and trackers",
+		},
+		{
+			Name:        "dns",
+			PluginTypes: []shared.PluginType{shared.PluginTypeReporter},
+			License:     "MIT",
+			Description: "Reports DNS statistics",
+		},
+	}
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "", expected: []string{"blocklist", "dns", "dns-resolver"}},
+		{query: "dns", expected: []string{"dns", "dns-resolver"}},
+		{query: "privacy", expected: []string{"blocklist", "dns-resolver"}},
+		{query: "bob", expected: []string{"blocklist"}},
+		{query: "trackers", expected: []string{"blocklist"}},
+		{query: "blocklsit", expected: []string{"blocklist"}},
+		{query: "dns ads"},
+		{query: "unknown"},
+
+		// facets
+		{query: "type:resolver", expected: []string{"dns-resolver"}},
+		{query: "license:mit", expected: []string{"dns", "dns-resolver"}},
+		{query: "privacy license:mit", expected: []string{"dns-resolver"}},
+		{query: "tag:ads type:decider", expected: []string{"blocklist"}},
+		{query: "tag:ads type:resolver"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			q, err := ParseQuery(c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, result := range SearchPlugins(plugins, q) {
+				names = append(names, result.Plugin.Name)
+
+				if len(q.Terms) == 0 && result.Score != 0 {
+					t.Errorf("%s: expected a score of zero for facet-only matches but got %d", result.Plugin.Name, result.Score)
+				}
+			}
+
+			if !reflect.DeepEqual(names, c.expected) {
+				t.Errorf("expected %v but got %v", c.expected, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Common errors returned when verifying index signatures.
+var (
+	ErrUnsignedIndex    = errors.New("repository index is not signed")
+	ErrInvalidSignature = errors.New("repository index signature is invalid")
+)
+
+// GenerateKey generates a new ed25519 key pair that can be used to sign repository
+// index files. Both keys are returned base64 encoded.
+func GenerateKey() (publicKey string, privateKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv), nil
+}
+
+// SignIndex creates a detached signature for the index file content in blob
+// using the base64 encoded ed25519 privateKey. The signature is returned
+// base64 encoded.
+func SignIndex(privateKey string, blob []byte) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(privateKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode private key: %w", err)
+	}
+
+	if len(key) != ed25519.PrivateKeySize {
+		return "", fmt.Errorf("invalid private key size %d", len(key))
+	}
+
+	sig := ed25519.Sign(ed25519.PrivateKey(key), blob)
+
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// PublicKey returns the base64 encoded public key of privateKey.
+func PublicKey(privateKey string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(privateKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode private key: %w", err)
+	}
+
+	if len(key) != ed25519.PrivateKeySize {
+		return "", fmt.Errorf("invalid private key size %d", len(key))
+	}
+
+	pub := ed25519.PrivateKey(key).Public().(ed25519.PublicKey)
+
+	return base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// VerifyIndex verifies the base64 encoded detached signature of the index file
+// content in blob. The signature must be created by one of the base64 encoded
+// ed25519 keys in trustedKeys.
+//
+// ErrUnsignedIndex is returned if signature is empty and ErrInvalidSignature is
+// returned if none of the trusted keys verifies the signature.
+func VerifyIndex(trustedKeys []string, blob []byte, signature string) error {
+	signature = strings.TrimSpace(signature)
+	if signature == "" {
+		return ErrUnsignedIndex
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	for _, trustedKey := range trustedKeys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(trustedKey))
+		if err != nil {
+			return fmt.Errorf("failed to decode trusted key %q: %w", trustedKey, err)
+		}
+
+		if len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid trusted key %q: invalid key size %d", trustedKey, len(key))
+		}
+
+		if ed25519.Verify(ed25519.PublicKey(key), blob, sig) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
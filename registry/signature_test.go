@@ -0,0 +1,166 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSignAndVerifyIndex(t *testing.T) {
+	pub, priv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherPub, otherPriv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	derived, err := PublicKey(priv)
+	if err != nil {
+		t.Fatalf("failed to derive public key: %s", err)
+	}
+	if derived != pub {
+		t.Fatalf("expected derived public key %s but got %s", pub, derived)
+	}
+
+	blob := []byte(`{"name": "test", "plugins": []}`)
+
+	sig, err := SignIndex(priv, blob)
+	if err != nil {
+		t.Fatalf("failed to sign index: %s", err)
+	}
+
+	otherSig, err := SignIndex(otherPriv, blob)
+	if err != nil {
+		t.Fatalf("failed to sign index: %s", err)
+	}
+
+	cases := []struct {
+		name        string
+		trustedKeys []string
+		blob        []byte
+		signature   string
+		expected    error
+	}{
+		{
+			name:        "round trip",
+			trustedKeys: []string{pub},
+			blob:        blob,
+			signature:   sig,
+		},
+		{
+			name:        "surrounding whitespace",
+			trustedKeys: []string{" " + pub + "\n"},
+			blob:        blob,
+			signature:   sig + "\n",
+		},
+		{
+			name:        "one of multiple trusted keys",
+			trustedKeys: []string{otherPub, pub},
+			blob:        blob,
+			signature:   sig,
+		},
+		{
+			name:        "tampered index",
+			trustedKeys: []string{pub},
+			blob:        []byte(`{"name": "evil", "plugins": []}`),
+			signature:   sig,
+			expected:    ErrInvalidSignature,
+		},
+		{
+			name:        "wrong key",
+			trustedKeys: []string{pub},
+			blob:        blob,
+			signature:   otherSig,
+			expected:    ErrInvalidSignature,
+		},
+		{
+			name:        "no trusted key matches",
+			trustedKeys: []string{otherPub},
+			blob:        blob,
+			signature:   sig,
+			expected:    ErrInvalidSignature,
+		},
+		{
+			name:        "missing signature",
+			trustedKeys: []string{pub},
+			blob:        blob,
+			signature:   "",
+			expected:    ErrUnsignedIndex,
+		},
+		{
+			name:        "blank signature",
+			trustedKeys: []string{pub},
+			blob:        blob,
+			signature:   " \n",
+			expected:    ErrUnsignedIndex,
+		},
+		{
+			name:        "malformed signature",
+			trustedKeys: []string{pub},
+			blob:        blob,
+			signature:   "not base64!",
+			expected:    ErrInvalidSignature,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := VerifyIndex(c.trustedKeys, c.blob, c.signature)
+
+			if c.expected == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, c.expected) {
+				t.Errorf("expected %v but got %v", c.expected, err)
+			}
+		})
+	}
+}
+
+func TestVerifyIndexInvalidKeys(t *testing.T) {
+	_, priv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob := []byte("index")
+
+	sig, err := SignIndex(priv, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"not base64!", "c2hvcnQ="} {
+		t.Run(key, func(t *testing.T) {
+			err := VerifyIndex([]string{key}, blob, sig)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("expected a key error but got %v", err)
+			}
+		})
+	}
+}
+
+func TestSignIndexInvalidKey(t *testing.T) {
+	pub, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// public keys have a different size than private keys.
+	for _, key := range []string{"not base64!", pub} {
+		if _, err := SignIndex(key, []byte("index")); err == nil {
+			t.Errorf("expected signing with %q to fail", key)
+		}
+	}
+}
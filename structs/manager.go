@@ -1,10 +1,31 @@
 package structs
 
+// Supported versions of the installed plugins state file.
+//
+// v1.0.0 declared the plugin description with hcl:",inline" which is not
+// supported by gohcl and made encoding and decoding the state file panic.
+// Starting with v1.1.0 the plugin description is stored in a nested plugin
+// block. As v1.0.0 files could never be written, v1.0.0 files are decoded
+// using the v1.1.0 layout.
+const (
+	StateFileVersion10 = "v1.0.0"
+	StateFileVersion11 = "v1.1.0"
+)
+
 type (
 	// InstalledPlugin describes a plugin installed by the manager.
 	InstalledPlugin struct {
-		PluginDesc `json:",inline" hcl:",inline"`
+		PluginDesc `json:",inline" hcl:"plugin,block"`
 		Path       string `hcl:"path"`
+
+		// UpdatePolicy holds a github.com/hashicorp/go-version constraint that
+		// restricts the versions the plugin may be updated to. An empty
+		// policy allows any update.
+		UpdatePolicy string `json:"updatePolicy,omitempty" hcl:"update_policy,optional"`
+
+		// FollowChannel may hold the release channel followed for updates of
+		// the plugin. If empty, the channel of the repository is used.
+		FollowChannel string `json:"followChannel,omitempty" hcl:"follow_channel,optional"`
 	}
 
 	AvailableUpdate struct {
@@ -13,6 +34,17 @@ type (
 		NewVersion     string `json:"newVersion"`
 	}
 
+	// Rollback describes a plugin update that has been reverted because
+	// the new version failed to start.
+	Rollback struct {
+		Name            string `json:"name"`
+		FailedVersion   string `json:"failedVersion"`
+		RestoredVersion string `json:"restoredVersion"`
+		Error           string `json:"error"`
+	}
+
+	// InstalledPluginsFile is the state file of the manager, see
+	// StateFileVersion11 for the current layout.
 	InstalledPluginsFile struct {
 		Version string            `hcl:"version"`
 		Plugins []InstalledPlugin `hcl:"plugins,block"`
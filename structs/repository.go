@@ -2,6 +2,13 @@ package structs
 
 import "github.com/safing/portmaster/plugin/shared"
 
+// Supported release channels ordered from most to least stable.
+const (
+	ChannelStable  = "stable"
+	ChannelBeta    = "beta"
+	ChannelNightly = "nightly"
+)
+
 type (
 	// Repository holds a repository configuration
 	// that is used to fetch available plugins and to check
@@ -20,12 +27,28 @@ type (
 		// are defined the priority decides which one wins when plugins are listed
 		// in multiple repositories.
 		Priority int `json:"priority" hcl:"priority"`
+
+		// Channel is the release channel followed for plugins of this repository.
+		// Only releases of the selected channel and more stable ones are offered
+		// as updates. Defaults to ChannelStable.
+		Channel string `json:"channel,omitempty" hcl:"channel,optional"`
+
+		// TrustedKeys holds a list of base64 encoded ed25519 public keys of the
+		// repository publishers. If set, the repository index must carry a
+		// detached signature created by one of those keys or it will be rejected.
+		TrustedKeys []string `json:"trustedKeys,omitempty" hcl:"trusted_keys,optional"`
+
+		// SignatureURL may hold the URL of the detached index signature.
+		// If empty, ".sig" is appended to URL.
+		SignatureURL string `json:"signatureURL,omitempty" hcl:"signature_url,optional"`
 	}
 
 	// IndexMeta holds additional information about a index file.
 	IndexMeta struct {
 		// Version is the version of the index file. This must be set
-		// to v1.0.0 right now.
+		// to either v1.0.0, v1.1.0 or v1.2.0. Indexes that define plugin
+		// releases must use v1.1.0 or later, indexes that define download
+		// blocks, template name mappings or archive formats must use v1.2.0.
 		Version string `json:"version" hcl:"version"`
 
 		// Description may hold a human readable description of the repository.
@@ -34,6 +57,9 @@ type (
 
 	// Artifact defines the download paths for different operating systems and
 	// architectures.
+	//
+	// Artifact only supports a fixed set of architectures, new indexes should
+	// use Download instead.
 	Artifact struct {
 		// OS is the name of the operating system this artifact is built for.
 		// This should follow the values from runtime.GOOS.
@@ -49,6 +75,57 @@ type (
 		ARM   string `json:"arm" hcl:"arm,optional"`
 		ARM64 string `json:"arm64" hcl:"arm64,optional"`
 		I386  string `json:"i386" hcl:"i386,optional"`
+
+		// SHA256 holds the hex encoded SHA-256 digests of the downloaded artifacts
+		// keyed by architecture (amd64, arm, arm64 or i386).
+		SHA256 map[string]string `json:"sha256,omitempty" hcl:"sha256,optional"`
+
+		// SHA512 holds the hex encoded SHA-512 digests of the downloaded artifacts
+		// keyed by architecture (amd64, arm, arm64 or i386).
+		SHA512 map[string]string `json:"sha512,omitempty" hcl:"sha512,optional"`
+	}
+
+	// Download defines the download of a plugin artifact for a dedicated
+	// platform.
+	Download struct {
+		// OS is the operating system this artifact is built for. This should
+		// follow the values from runtime.GOOS.
+		OS string `json:"os" hcl:"os"`
+
+		// Arch is the architecture this artifact is built for. This should
+		// follow the values from runtime.GOARCH.
+		Arch string `json:"arch" hcl:"arch"`
+
+		// Variant may hold the architecture variant, like v6 or v7 for arm or
+		// v1 to v4 for the amd64 micro-architecture levels. Artifacts built
+		// for a lower variant are also used for higher variants.
+		Variant string `json:"variant,omitempty" hcl:"variant,optional"`
+
+		// Libc may hold the C library the artifact is linked against. Either
+		// glibc or musl.
+		Libc string `json:"libc,omitempty" hcl:"libc,optional"`
+
+		// MinOSVersion may hold the minimum version of the operating system
+		// required by the artifact. On Linux, this is the kernel version.
+		MinOSVersion string `json:"minOSVersion,omitempty" hcl:"min_os_version,optional"`
+
+		// URL is the download URL of the artifact.
+		URL string `json:"url" hcl:"url"`
+
+		// ArchiveFile holds the path or glob pattern of the plugin binary if
+		// the downloaded artifact is an archive and contains more than one
+		// file. See PluginDesc.ArchiveFile.
+		ArchiveFile string `json:"archiveFile,omitempty" hcl:"archive_file,optional"`
+
+		// Format may hold the archive format of the artifact and overwrites
+		// PluginDesc.ArchiveFormat.
+		Format string `json:"format,omitempty" hcl:"format,optional"`
+
+		// SHA256 may hold the hex encoded SHA-256 digest of the artifact.
+		SHA256 string `json:"sha256,omitempty" hcl:"sha256,optional"`
+
+		// SHA512 may hold the hex encoded SHA-512 digest of the artifact.
+		SHA512 string `json:"sha512,omitempty" hcl:"sha512,optional"`
 	}
 
 	// PluginDesc describes a plugin and additional meta data.
@@ -60,40 +137,79 @@ type (
 		// SourceURL should point to the source code repository of the plugin.
 		SourceURL string `json:"source" hcl:"source"`
 
-		// Version is the current version of the plugin.
-		Version string `json:"version" hcl:"version"`
+		// Version is the current version of the plugin. Version may be omitted
+		// if the plugin defines Releases.
+		Version string `json:"version" hcl:"version,optional"`
 
-		// ArtifactTemplate contains a template string using github.com/valyala/fasttemplate
-		// that is used to craft a download link for the target architecture.
+		// ArtifactTemplate contains a template string that is used to craft a
+		// download link for the target architecture.
 		//
 		// If it's not possible to define a download link using templating
 		// the artifacts can be defined in the Artifacts member below.
 		//
-		// If the resulting URL ends in .tar.gz it will be unpacked automatically.
+		// The artifact is unpacked according to ArchiveFormat.
 		//
 		// For the template, the following substitutions are available:
-		//	- {{os}}: The value of runtime.GOOS
-		//  - {{arch}}: The value of runtime.GOARCH
+		//	- {{os}}: The value of runtime.GOOS, mapped using OSNames
+		//  - {{arch}}: The value of runtime.GOARCH, mapped using ArchNames
+		//  - {{variant}}: The architecture variant of the target system, like v7, if known
+		//  - {{ext}}: The archive extension for the target system, see Extensions
 		//  - {{version}}: The value of the Version member
 		//  - {{stripped_version}}: The value of the Version member but with leading 'v' removed
 		//  - {{source}}: The value of the Source member.
 		//  - {{plugin_name}}: The value of the Name member
+		//  - {{archive_file}}: The value of the ArchiveFile member
+		//
+		// Filters may be applied to substitutions using {{name|filter}}. Supported
+		// filters are lower, upper, title and trimv, which removes a leading 'v'.
 		//
 		// For example, a ArtifactTemplate for a plugin released to github via goreleaser might look
 		// like:
 		//
-		//	${source}/releases/download/${version}/${pugin_name}_${stripped_version}_${os}_${arch}.tar.gz
+		//	{{source}}/releases/download/{{version}}/{{plugin_name}}_{{stripped_version}}_{{os|title}}_{{arch}}.{{ext}}
 		//
 		ArtifactTemplate string `json:"artifact_template" hcl:"artifact_template,optional"`
 
 		// ArchiveFile holds the name of the plugin binary if the downloaded artifact is
-		// an archive and contains more than one file.
+		// an archive and contains more than one file. It may also hold a slash
+		// separated path, like "plugin_linux_amd64/plugin", or a glob pattern, like
+		// "*/plugin", that must match exactly one file in the archive. If empty,
+		// the archive is searched for a file named like the plugin.
 		//
 		// Note that it's also possible to specify the ArchiveFile in dedicated Artifact
 		// definitions below as well. If both are specified, the ArchiveFile in the Artifact
 		// takes precendence.
 		ArchiveFile string `json:"archiveFile" hcl:"archive_file,optional"`
 
+		// ArchiveFormat may hold the archive format of the plugin artifacts. Supported
+		// formats are tar.gz, tar.xz, tar.bz2, zip, gz, xz and bz2 for a compressed
+		// binary and binary for artifacts that are not packed at all. If empty, the
+		// format is detected from the extension of the download URL.
+		ArchiveFormat string `json:"archiveFormat,omitempty" hcl:"archive_format,optional"`
+
+		// OSNames maps values of runtime.GOOS to the name used by the
+		// {{os}} substitution, like darwin = "macOS".
+		OSNames map[string]string `json:"osNames,omitempty" hcl:"os_names,optional"`
+
+		// ArchNames maps values of runtime.GOARCH to the name used by the
+		// {{arch}} substitution, like amd64 = "x86_64". Keys may include the
+		// architecture variant, like "arm/v7", which take precedence.
+		ArchNames map[string]string `json:"archNames,omitempty" hcl:"arch_names,optional"`
+
+		// Extensions maps values of runtime.GOOS to the value of the {{ext}}
+		// substitution, like windows = "zip". The key "default" is used for
+		// all other operating systems. Defaults to "tar.gz".
+		Extensions map[string]string `json:"extensions,omitempty" hcl:"extensions,optional"`
+
+		// Checksums may hold the URL of a checksums file that contains the digests
+		// of all artifacts, like the checksums.txt created by goreleaser. The
+		// same substitutions as for ArtifactTemplate are available.
+		//
+		// The checksums file is only used if the matching artifact does not
+		// specify a digest itself. Both, GNU and BSD style checksum files
+		// are supported.
+		Checksums string `json:"checksums,omitempty" hcl:"checksums,optional"`
+
 		// Artifacts defines the download URLs for the plugin binary for
 		// different architectures and operating systems.
 		//
@@ -101,6 +217,15 @@ type (
 		// if there's a matching architecutre definition.
 		Artifacts []Artifact `json:"artifacts" hcl:"artifact,block"`
 
+		// Downloads defines the download URLs for the plugin binary per
+		// platform. Unlike Artifacts, Downloads support any combination of
+		// operating system and architecture as well as architecture variants,
+		// the C library and the minimum operating system version.
+		//
+		// Downloads and Artifacts are merged and the best matching artifact
+		// is used. ArtifactTemplate is only used if none of them match.
+		Downloads []Download `json:"downloads,omitempty" hcl:"download,block"`
+
 		// PluginTypes defines the list of plugin types implemented
 		// by the described plugin.
 		PluginTypes []shared.PluginType `json:"pluginTypes" hcl:"pluginTypes"`
@@ -122,9 +247,56 @@ type (
 		// Tags holds an arbitrary list of tags for the plugin.
 		Tags []string `json:"tags" hcl:"tags,optional"`
 
+		// Channel is the release channel of the plugin version. If empty, versions
+		// with a semver pre-release tag are part of ChannelBeta and all others
+		// are part of ChannelStable.
+		Channel string `json:"channel,omitempty" hcl:"channel,optional"`
+
+		// Releases may hold a list of plugin releases. If set, each release
+		// describes a dedicated version of the plugin and the artifact definitions
+		// of the plugin are used as defaults for all releases.
+		Releases []Release `json:"releases,omitempty" hcl:"release,block"`
+
 		// Repository is the name of the repository that contains the
-		// plugin.
-		Repository string `json:"repository"`
+		// plugin. It is set by the registry and persisted in the manager
+		// state so updates of installed plugins stay on the same
+		// repository. It is ignored when decoding repository indexes.
+		Repository string `json:"repository" hcl:"repository,optional"`
+	}
+
+	// Release describes a dedicated version of a plugin. All artifact related
+	// members overwrite the respective values of the PluginDesc if set.
+	Release struct {
+		// Version is the version of the plugin release.
+		Version string `json:"version" hcl:",label"`
+
+		// ArtifactTemplate overwrites PluginDesc.ArtifactTemplate.
+		ArtifactTemplate string `json:"artifact_template" hcl:"artifact_template,optional"`
+
+		// ArchiveFile overwrites PluginDesc.ArchiveFile.
+		ArchiveFile string `json:"archiveFile" hcl:"archive_file,optional"`
+
+		// ArchiveFormat overwrites PluginDesc.ArchiveFormat.
+		ArchiveFormat string `json:"archiveFormat,omitempty" hcl:"archive_format,optional"`
+
+		// Checksums overwrites PluginDesc.Checksums.
+		Checksums string `json:"checksums,omitempty" hcl:"checksums,optional"`
+
+		// Artifacts overwrites PluginDesc.Artifacts. If either Artifacts or
+		// Downloads is set, both members of the PluginDesc are replaced.
+		Artifacts []Artifact `json:"artifacts" hcl:"artifact,block"`
+
+		// Downloads overwrites PluginDesc.Downloads, see Artifacts.
+		Downloads []Download `json:"downloads,omitempty" hcl:"download,block"`
+
+		// PluginTypes overwrites PluginDesc.PluginTypes.
+		PluginTypes []shared.PluginType `json:"pluginTypes" hcl:"pluginTypes,optional"`
+
+		// Privileged overwrites PluginDesc.Privileged.
+		Privileged *bool `json:"privileged,omitempty" hcl:"privileged,optional"`
+
+		// Channel overwrites PluginDesc.Channel.
+		Channel string `json:"channel,omitempty" hcl:"channel,optional"`
 	}
 
 	// RepositoryIndex defines the structure of a repository index file.